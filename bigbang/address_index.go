@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	addressTxBucket = "address_tx"
+)
+
+//地址交易索引的资金方向
+const (
+	AddressTxDirectionIn   = "in"   //转入
+	AddressTxDirectionOut  = "out"  //转出
+	AddressTxDirectionSelf = "self" //转给自己
+)
+
+//AddressTx 地址交易索引，记录已监听地址相关的交易
+type AddressTx struct {
+	ID          string `storm:"id"` //address_txid_sourceKey
+	Address     string `storm:"index"`
+	SourceKey   string //地址所属的源标识，ExtractData为该源标识的提取结果
+	TxID        string
+	BlockHeight uint64
+	BlockHash   string `storm:"index"`
+	Direction   string
+	Amount      string //该地址转入或转出的金额，不含找零和手续费
+	Fee         string
+	ContractID  string
+	ExtractData *openwallet.TxExtractData
+	CreateAt    int64
+}
+
+//indexExtractData 把提取结果中的地址记录到地址交易索引
+func (bs *BBCBlockScanner) indexExtractData(extractData map[string]*openwallet.TxExtractData) error {
+
+	if !bs.wm.Config.AddressIndex || len(extractData) == 0 {
+		return nil
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.From(addressTxBucket).Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	createAt := time.Now().Unix()
+
+	for sourceKey, data := range extractData {
+		trx := data.Transaction
+		if trx == nil {
+			continue
+		}
+
+		directions := make(map[string]string)
+		for _, input := range data.TxInputs {
+			directions[input.Address] = AddressTxDirectionOut
+		}
+		for _, output := range data.TxOutputs {
+			//找零回到发送方不改变资金方向
+			if output.Index == uint64(VoutChange) {
+				continue
+			}
+			if directions[output.Address] == AddressTxDirectionOut {
+				directions[output.Address] = AddressTxDirectionSelf
+			} else {
+				directions[output.Address] = AddressTxDirectionIn
+			}
+		}
+
+		for address, direction := range directions {
+			record := &AddressTx{
+				ID:          address + "_" + trx.TxID + "_" + sourceKey,
+				Address:     address,
+				SourceKey:   sourceKey,
+				TxID:        trx.TxID,
+				BlockHeight: trx.BlockHeight,
+				BlockHash:   trx.BlockHash,
+				Direction:   direction,
+				Amount:      addressTxAmount(data, address, direction),
+				Fee:         trx.Fees,
+				ContractID:  trx.Coin.ContractID,
+				ExtractData: data,
+				CreateAt:    createAt,
+			}
+			err = tx.Save(record)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+//addressTxAmount 地址在交易中的金额，转入为收到的金额，转出为输入扣除找零和手续费后转给其他地址的金额
+func addressTxAmount(data *openwallet.TxExtractData, address, direction string) string {
+
+	var (
+		received = decimal.Zero
+		change   = decimal.Zero
+		spent    = decimal.Zero
+	)
+
+	for _, output := range data.TxOutputs {
+		if output.Address != address {
+			continue
+		}
+		amount, _ := decimal.NewFromString(output.Amount)
+		if output.Index == uint64(VoutChange) {
+			change = change.Add(amount)
+		} else {
+			received = received.Add(amount)
+		}
+	}
+
+	if direction != AddressTxDirectionOut {
+		return received.String()
+	}
+
+	for _, input := range data.TxInputs {
+		if input.Address != address {
+			continue
+		}
+		amount, _ := decimal.NewFromString(input.Amount)
+		spent = spent.Add(amount)
+	}
+
+	fee, _ := decimal.NewFromString(data.Transaction.Fees)
+	sent := spent.Sub(change).Sub(fee)
+	if sent.IsNegative() {
+		return decimal.Zero.String()
+	}
+	return sent.String()
+}
+
+//uniqueAddressTx 同一源标识的同一交易单只匹配一次，查询的多个地址在同一交易中时只返回一条
+type uniqueAddressTx struct {
+	seen map[string]bool
+}
+
+func (m *uniqueAddressTx) Match(i interface{}) (bool, error) {
+	var record AddressTx
+	switch v := i.(type) {
+	case AddressTx:
+		record = v
+	case *AddressTx:
+		record = *v
+	default:
+		return false, nil
+	}
+	key := record.TxID + "_" + record.SourceKey
+	if m.seen[key] {
+		return false, nil
+	}
+	m.seen[key] = true
+	return true, nil
+}
+
+//GetAddressTxs 获取地址交易索引，按区块高度从新到旧排序
+func (wm *WalletManager) GetAddressTxs(address ...string) ([]*AddressTx, error) {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*AddressTx
+	err = db.From(addressTxBucket).Select(q.In("Address", address)).OrderBy("BlockHeight", "TxID").Reverse().Find(&list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//deleteAddressTxs 删除孤块中的地址交易索引
+func (bs *BBCBlockScanner) deleteAddressTxs(hash string) error {
+
+	if !bs.wm.Config.AddressIndex {
+		return nil
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	err = db.From(addressTxBucket).Select(q.Eq("BlockHash", hash)).Delete(new(AddressTx))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//GetTransactionsByAddress 从地址交易索引分页查询地址相关的交易记录，按区块高度从新到旧排序。
+//开启addressIndex时只包含开启后扫描或回填的交易，没有开启时使用节点接口查询。
+func (bs *BBCBlockScanner) GetTransactionsByAddress(offset, limit int, coin openwallet.Coin, address ...string) ([]*openwallet.TxExtractData, error) {
+
+	if !bs.wm.Config.AddressIndex {
+		return bs.getTransactionsByAddressFromNode(offset, limit, coin, address...)
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	contractID := ""
+	if coin.IsContract {
+		contractID = coin.ContractID
+	}
+
+	//去重放在最后，只对满足其他条件的记录计数
+	query := db.From(addressTxBucket).Select(
+		q.In("Address", address),
+		q.Eq("ContractID", contractID),
+		&uniqueAddressTx{seen: make(map[string]bool)},
+	).OrderBy("BlockHeight", "TxID").Reverse().Skip(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var list []*AddressTx
+	err = query.Find(&list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	array := make([]*openwallet.TxExtractData, 0, len(list))
+	for _, record := range list {
+		array = append(array, record.ExtractData)
+	}
+
+	return array, nil
+}
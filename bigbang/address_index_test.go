@@ -0,0 +1,136 @@
+package bigbang
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestGetTransactionsByAddress_Index(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		switch txid {
+		case "txid_out":
+			return testTransaction(txid, "1deposit", "1other", 3), nil
+		case "txid_self":
+			return testTransaction(txid, "1deposit", "1change", 3), nil
+		}
+		return testTransaction(txid, "1sender", "1deposit", 3), nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1deposit", "1change")
+	defer closeTestWalletManager(wm)
+	wm.Config.AddressIndex = true
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+
+	for height := uint64(1); height <= 5; height++ {
+		err := bs.BatchExtractTransaction(height, fmt.Sprintf("hash_%d", height), []string{fmt.Sprintf("txid_%d", height)}, false)
+		if err != nil {
+			t.Fatalf("BatchExtractTransaction failed, unexpected error: %v", err)
+		}
+	}
+	bs.BatchExtractTransaction(6, "hash_6", []string{"txid_out"}, false)
+	bs.BatchExtractTransaction(7, "hash_7", []string{"txid_self"}, false)
+
+	records, err := wm.GetAddressTxs("1deposit")
+	if err != nil || len(records) != 7 {
+		t.Fatalf("address index should have 7 records, got: %d, err: %v", len(records), err)
+	}
+	if records[0].TxID != "txid_self" || records[1].Direction != AddressTxDirectionOut || records[2].Direction != AddressTxDirectionIn {
+		t.Errorf("address index should be ordered by height with directions, got: %s %s %s", records[0].TxID, records[1].Direction, records[2].Direction)
+	}
+
+	//按高度从新到旧分页
+	coin := openwallet.Coin{Symbol: wm.Symbol()}
+	page, err := bs.GetTransactionsByAddress(2, 3, coin, "1deposit", "1change")
+	if err != nil {
+		t.Fatalf("GetTransactionsByAddress failed, unexpected error: %v", err)
+	}
+	if len(page) != 3 || page[0].Transaction.TxID != "txid_5" || page[2].Transaction.TxID != "txid_3" {
+		t.Errorf("unexpected page, got: %d", len(page))
+	}
+
+	//同一交易单只返回一次
+	all, _ := bs.GetTransactionsByAddress(0, 0, coin, "1deposit", "1change")
+	if len(all) != 7 {
+		t.Errorf("transactions should be deduplicated by txid, got: %d", len(all))
+	}
+
+	//孤块的索引删除
+	bs.deleteAddressTxs("hash_7")
+	all, _ = bs.GetTransactionsByAddress(0, 0, coin, "1deposit", "1change")
+	if len(all) != 6 {
+		t.Errorf("orphan transactions should be removed from index, got: %d", len(all))
+	}
+}
+
+func TestGetTransactionsByAddress_SourceKeysAndAmount(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction(params["txid"].(string), "1alice", "1bob", 3), nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+	wm.Config.AddressIndex = true
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+	sourceKeys := map[string]string{"1alice": "alice", "1bob": "bob"}
+	bs.SetBlockScanAddressFunc(func(address string) (string, bool) {
+		sourceKey, ok := sourceKeys[address]
+		return sourceKey, ok
+	})
+
+	for height := uint64(1); height <= 3; height++ {
+		bs.BatchExtractTransaction(height, fmt.Sprintf("hash_%d", height), []string{fmt.Sprintf("txid_%d", height)}, false)
+	}
+
+	//每个地址只记录自己的金额
+	records, _ := wm.GetAddressTxs("1alice", "1bob")
+	for _, record := range records {
+		if record.Address == "1bob" && (record.Direction != AddressTxDirectionIn || record.Amount != "1.5" || record.SourceKey != "bob") {
+			t.Errorf("receiver should record its received amount, got: %+v", record)
+		}
+		if record.Address == "1alice" && (record.Direction != AddressTxDirectionOut || record.Amount != "1.5" || record.SourceKey != "alice") {
+			t.Errorf("sender should record its sent amount, got: %+v", record)
+		}
+	}
+
+	//不同源标识的提取结果各自返回，并在查询中分页
+	coin := openwallet.Coin{Symbol: wm.Symbol()}
+	all, err := bs.GetTransactionsByAddress(0, 0, coin, "1alice", "1bob")
+	if err != nil || len(all) != 6 {
+		t.Fatalf("extract data of both source keys should be returned, got: %d, %v", len(all), err)
+	}
+	page, _ := bs.GetTransactionsByAddress(1, 2, coin, "1alice", "1bob")
+	if len(page) != 2 || page[0].Transaction.TxID != "txid_3" || page[1].Transaction.TxID != "txid_2" {
+		t.Errorf("unexpected page, got: %d", len(page))
+	}
+	if page, _ := bs.GetTransactionsByAddress(10, 2, coin, "1alice", "1bob"); len(page) != 0 {
+		t.Errorf("page over the end should be empty, got: %d", len(page))
+	}
+}
+
+func TestGetTransactionsByAddress_WithoutIndex(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, _ := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+
+	//没有开启地址交易索引时使用节点接口查询
+	coin := openwallet.Coin{Symbol: wm.Symbol()}
+	list, err := wm.Blockscanner.GetTransactionsByAddress(0, 10, coin, "1deposit")
+	if err != nil || len(list) != 0 {
+		t.Errorf("GetTransactionsByAddress should fall back to the node, got: %d, %v", len(list), err)
+	}
+}
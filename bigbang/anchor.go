@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+//isValidHash 是否32字节的hex哈希
+func isValidHash(hash string) bool {
+	b, err := hex.DecodeString(hash)
+	return err == nil && len(b) == 32
+}
+
+//getAnchor 获取主链anchor，即创世区块hash，优先使用配置值，否则向节点查询一次后缓存
+func (wm *WalletManager) getAnchor() (string, error) {
+	wm.anchorMu.Lock()
+	defer wm.anchorMu.Unlock()
+
+	if len(wm.anchor) > 0 {
+		return wm.anchor, nil
+	}
+
+	if len(wm.Config.Anchor) > 0 {
+		wm.anchor = wm.Config.Anchor
+		return wm.anchor, nil
+	}
+
+	anchor, err := wm.Client.getAnchor()
+	if err != nil {
+		return "", err
+	}
+	if !isValidHash(anchor) {
+		return "", fmt.Errorf("invalid anchor: %s from node", anchor)
+	}
+
+	wm.anchor = anchor
+	return wm.anchor, nil
+}
+
+//resolveAnchor 加载配置时解析并校验主链和子链的anchor，节点不可用时延后到首次使用时查询
+func (wm *WalletManager) resolveAnchor() error {
+
+	wm.anchorMu.Lock()
+	wm.anchor = ""
+	wm.anchorMu.Unlock()
+
+	if len(wm.Config.Anchor) > 0 && !isValidHash(wm.Config.Anchor) {
+		return fmt.Errorf("invalid anchor: %s in config", wm.Config.Anchor)
+	}
+
+	for fork := range wm.Config.SubForks {
+		if !isValidHash(fork) {
+			return fmt.Errorf("invalid sub fork hash: %s in config", fork)
+		}
+	}
+
+	nodeAnchor, err := wm.Client.getAnchor()
+	if err != nil {
+		wm.Log.Std.Info("can not get anchor from node, resolve it later; unexpected error: %v", err)
+		return nil
+	}
+
+	if len(wm.Config.Anchor) > 0 && wm.Config.Anchor != nodeAnchor {
+		return fmt.Errorf("anchor: %s in config is not match the node: %s", wm.Config.Anchor, nodeAnchor)
+	}
+
+	if !isValidHash(nodeAnchor) {
+		return fmt.Errorf("invalid anchor: %s from node", nodeAnchor)
+	}
+
+	wm.anchorMu.Lock()
+	wm.anchor = nodeAnchor
+	wm.anchorMu.Unlock()
+	return nil
+}
@@ -0,0 +1,38 @@
+package bigbang
+
+import (
+	"testing"
+)
+
+func TestGetAnchor_Cached(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	anchor := "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+	node.handle("getblockhash", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return []string{anchor}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	if err := wm.resolveAnchor(); err != nil {
+		t.Fatalf("resolveAnchor failed, unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := wm.getAnchor()
+		if err != nil || got != anchor {
+			t.Fatalf("getAnchor expected: %s, got: %s, error: %v", anchor, got, err)
+		}
+	}
+
+	if n := node.callCount("getblockhash"); n != 1 {
+		t.Errorf("getblockhash should be called once, got %d", n)
+	}
+
+	wm.Config.Anchor = "00000000b137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+	if err := wm.resolveAnchor(); err == nil {
+		t.Errorf("anchor not match the node should be rejected")
+	}
+}
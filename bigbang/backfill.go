@@ -0,0 +1,129 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//BackfillFunc 回填交易的回调，sourceKey为地址所属的源标识
+type BackfillFunc func(sourceKey string, data *openwallet.TxExtractData) error
+
+//Backfill 回填指定地址在主链高度区间内的历史交易，只提取这些地址相关的交易，通过callback返回。
+//不移动主扫描高度，不记录未扫记录，不通知观察者，可与ScanBlockTask同时运行。
+//与扫描一样记录这些地址相关交易的输出，开启localUTXO时写入UTXO，开启addressIndex时记录到地址交易索引，
+//延后导入的地址回填后即可使用本地UTXO。
+//endHeight为0或超过节点高度时回填至节点最新高度。返回错误时已回填的区块不会回滚，可从出错高度继续。
+//地址的源标识优先使用扫描器的ScanAddressFunc，没有则使用地址本身。
+func (bs *BBCBlockScanner) Backfill(startHeight, endHeight uint64, addresses []string, callback BackfillFunc) error {
+
+	if callback == nil {
+		return fmt.Errorf("backfill callback is not setup")
+	}
+
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	maxHeight, err := bs.wm.Client.getBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	if endHeight == 0 || endHeight > maxHeight {
+		endHeight = maxHeight
+	}
+
+	if startHeight > endHeight {
+		return fmt.Errorf("backfill start height: %d is over end height: %d", startHeight, endHeight)
+	}
+
+	watched := make(map[string]bool)
+	for _, address := range addresses {
+		watched[address] = true
+	}
+
+	scanAddressFunc := func(address string) (string, bool) {
+		if !watched[address] {
+			return "", false
+		}
+		if bs.ScanAddressFunc != nil {
+			if sourceKey, ok := bs.ScanAddressFunc(address); ok {
+				return sourceKey, true
+			}
+		}
+		return address, true
+	}
+
+	prefetcher := newBlockPrefetcher(bs.wm.Config.PrefetchWindow, bs.fetchBlockByHeight)
+	defer prefetcher.Stop()
+
+	for height := startHeight; height <= endHeight; height++ {
+
+		block, err := prefetcher.Get(height, endHeight)
+		if err != nil {
+			return fmt.Errorf("backfill can not get block on height: %d; unexpected error: %v", height, err)
+		}
+
+		bs.wm.Log.Std.Info("block scanner backfilling height: %d ...", height)
+
+		err = bs.backfillBlock(block, scanAddressFunc, callback)
+		if err != nil {
+			return fmt.Errorf("backfill failed on height: %d; unexpected error: %v", height, err)
+		}
+	}
+
+	return nil
+}
+
+//backfillBlock 提取区块内指定地址的交易并回调
+func (bs *BBCBlockScanner) backfillBlock(block *Block, scanAddressFunc openwallet.BlockScanAddressFunc, callback BackfillFunc) error {
+
+	txs, failures := bs.blockTransactions(block)
+	if len(failures) > 0 {
+		return fmt.Errorf("can not get transaction: %s; unexpected error: %s", failures[0].TxID, failures[0].Reason)
+	}
+
+	for _, trx := range txs {
+		result := ExtractResult{
+			BlockHeight: block.Height,
+			TxID:        trx.TxID,
+			extractData: make(map[string]*openwallet.TxExtractData),
+			Success:     true,
+		}
+
+		bs.extractTransaction(trx, &result, scanAddressFunc)
+		if !result.Success {
+			return fmt.Errorf("extract transaction: %s failed: %s", trx.TxID, result.Reason)
+		}
+
+		err := bs.indexExtractData(result.extractData)
+		if err != nil {
+			return err
+		}
+
+		for sourceKey, data := range result.extractData {
+			err = callback(sourceKey, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
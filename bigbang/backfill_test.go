@@ -0,0 +1,124 @@
+package bigbang
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestBackfill(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	chain := &testChain{
+		hashes: []string{"h0", "h1", "h2", "h3", "h4"},
+		txs: map[string][]string{
+			"h1": {"txid_1"},
+			"h2": {"txid_2", "txid_3"},
+			"h4": {"txid_4"},
+		},
+	}
+	recipients := map[string]string{
+		"txid_1": "1imported",
+		"txid_2": "1other",
+		"txid_3": "1imported",
+		"txid_4": "1imported",
+	}
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		return testTransaction(txid, "1sender", recipients[txid], 3), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	chain.setup(node)
+	wm.Config.PrefetchWindow = 2
+	wm.Config.LocalUTXO = true
+
+	bs := wm.Blockscanner
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+
+	backfilled := make([]*openwallet.TxExtractData, 0)
+	sourceKeys := make([]string, 0)
+	err := bs.Backfill(1, 3, []string{"1imported"}, func(sourceKey string, data *openwallet.TxExtractData) error {
+		sourceKeys = append(sourceKeys, sourceKey)
+		backfilled = append(backfilled, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Backfill failed, unexpected error: %v", err)
+	}
+
+	//只回填区间内指定地址的交易
+	if len(backfilled) != 2 || backfilled[0].Transaction.TxID != "txid_1" || backfilled[1].Transaction.TxID != "txid_3" {
+		t.Fatalf("backfill should extract the imported address in range, got: %d", len(backfilled))
+	}
+	if sourceKeys[0] != "1imported" || backfilled[1].Transaction.BlockHeight != 2 {
+		t.Errorf("unexpected backfill data, sourceKey: %s, height: %d", sourceKeys[0], backfilled[1].Transaction.BlockHeight)
+	}
+
+	//不影响主扫描
+	if len(observer.transactions()) != 0 {
+		t.Errorf("backfill should not notify scanner observers")
+	}
+	if header, _ := dai.GetCurrentBlockHead(wm.Symbol()); header != nil {
+		t.Errorf("backfill should not move the scan cursor")
+	}
+
+	//只记录指定地址相关交易的输出和UTXO
+	db, _ := wm.LocalDB.Open()
+	if n, _ := db.From(outPointBucket).Count(&OutPoint{}); n != 2 {
+		t.Errorf("backfill should save outpoints of the imported address, got: %d", n)
+	}
+	if unspent, _ := bs.unspentUTXOs("1imported", wm.Config.Anchor); len(unspent) != 2 {
+		t.Errorf("backfill should save utxos of the imported address, got: %d", len(unspent))
+	}
+	if unspent, _ := bs.unspentUTXOs("1other", wm.Config.Anchor); len(unspent) != 0 {
+		t.Errorf("backfill should not save utxos of other addresses, got: %d", len(unspent))
+	}
+}
+
+func TestBackfill_FallbackOneByOne(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+	node.batchUnsupported = true
+
+	chain := &testChain{
+		hashes: []string{"h0", "h1"},
+		txs:    map[string][]string{"h1": {"txid_1", "txid_2"}},
+	}
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		return testTransaction(txid, "1sender", "1imported", 3), nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+	chain.setup(node)
+	wm.Config.AddressIndex = true
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+
+	backfilled := make([]string, 0)
+	err := bs.Backfill(1, 1, []string{"1imported"}, func(sourceKey string, data *openwallet.TxExtractData) error {
+		backfilled = append(backfilled, data.Transaction.TxID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Backfill failed, unexpected error: %v", err)
+	}
+
+	//批量获取失败时按区块顺序逐笔获取
+	if len(backfilled) != 2 || backfilled[0] != "txid_1" || backfilled[1] != "txid_2" {
+		t.Fatalf("backfill should fall back to per-tx fetching in block order, got: %v", backfilled)
+	}
+
+	//开启地址交易索引时只写入索引
+	coin := openwallet.Coin{Symbol: wm.Symbol()}
+	indexed, err := bs.GetTransactionsByAddress(0, 10, coin, "1imported")
+	if err != nil || len(indexed) != 2 {
+		t.Errorf("backfilled txs should be indexed, got: %d, %v", len(indexed), err)
+	}
+}
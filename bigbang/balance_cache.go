@@ -0,0 +1,215 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"sync"
+	"time"
+)
+
+//balanceCacheEntry 地址在某个分支某个高度上的余额
+type balanceCacheEntry struct {
+	height  uint64
+	balance *AddrBalance
+	expire  time.Time
+}
+
+//balanceCache 地址余额缓存，按地址、分支和高度命中，扫描器提取到地址相关交易时失效
+type balanceCache struct {
+	mu       sync.Mutex
+	entries  map[string]map[string]*balanceCacheEntry //address -> anchor -> entry
+	loading  map[string]int                           //地址正在查询的次数
+	versions map[string]uint64                        //查询期间地址的失效次数，失效后的结果不写入，没有查询时不记录
+}
+
+func newBalanceCache() *balanceCache {
+	return &balanceCache{
+		entries:  make(map[string]map[string]*balanceCacheEntry),
+		loading:  make(map[string]int),
+		versions: make(map[string]uint64),
+	}
+}
+
+//get 获取未过期且高度一致的缓存。没有命中时记录地址正在查询，返回当前的失效版本，查询后需调用set或cancel
+func (c *balanceCache) get(address, anchor string, height uint64) (*AddrBalance, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[address][anchor]
+	if entry != nil && entry.height == height && !time.Now().After(entry.expire) {
+		return entry.balance, 0
+	}
+
+	c.loading[address]++
+	return nil, c.versions[address]
+}
+
+//set 写入查询结果，查询期间地址已失效则忽略
+func (c *balanceCache) set(address, anchor string, height, version uint64, balance *AddrBalance, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.versions[address] == version {
+		anchors := c.entries[address]
+		if anchors == nil {
+			anchors = make(map[string]*balanceCacheEntry)
+			c.entries[address] = anchors
+		}
+		anchors[anchor] = &balanceCacheEntry{
+			height:  height,
+			balance: balance,
+			expire:  time.Now().Add(ttl),
+		}
+	}
+
+	c.done(address)
+}
+
+//cancel 查询失败，不写入缓存
+func (c *balanceCache) cancel(address string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done(address)
+}
+
+//done 地址的一次查询结束，没有查询时删除失效版本
+func (c *balanceCache) done(address string) {
+	c.loading[address]--
+	if c.loading[address] > 0 {
+		return
+	}
+	delete(c.loading, address)
+	delete(c.versions, address)
+}
+
+//invalidate 地址的余额发生变化，删除全部分支的缓存，正在查询的结果不再写入
+func (c *balanceCache) invalidate(address ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, addr := range address {
+		if len(addr) == 0 {
+			continue
+		}
+		delete(c.entries, addr)
+		if c.loading[addr] > 0 {
+			c.versions[addr]++
+		}
+	}
+}
+
+//reset 清空全部缓存，用于分叉回滚后无法确定受影响地址的情况
+func (c *balanceCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr := range c.loading {
+		c.versions[addr]++
+	}
+	c.entries = make(map[string]map[string]*balanceCacheEntry)
+}
+
+//getBalanceHeight 获取余额对应的高度，本地UTXO集合为分支已扫描高度，否则为节点上分支的当前高度
+func (wm *WalletManager) getBalanceHeight(anchor string) (uint64, error) {
+
+	if wm.Config.LocalUTXO {
+		return wm.Blockscanner.anchorScannedHeight(anchor)
+	}
+
+	return wm.getAnchorBlockHeight(anchor)
+}
+
+//getAddressBalances 并发获取多个地址在分支上的余额，结果与地址顺序一致。
+//balanceCacheTTL大于0时按地址和高度缓存，高度变化或扫描器提取到地址相关交易时重新查询。
+func (wm *WalletManager) getAddressBalances(address []string, anchor string) ([]*AddrBalance, error) {
+
+	var (
+		ttl      = wm.Config.BalanceCacheTTL
+		height   uint64
+		err      error
+		balances = make([]*AddrBalance, len(address))
+		versions = make([]uint64, len(address))
+		misses   = make([]int, 0, len(address))
+	)
+
+	//获取不到高度时不使用缓存
+	if ttl > 0 {
+		height, err = wm.getBalanceHeight(anchor)
+		if err != nil {
+			wm.Log.Std.Info("balance cache is skipped, can not get block height; unexpected error: %v", err)
+			ttl = 0
+		}
+	}
+
+	for i, addr := range address {
+		if ttl > 0 {
+			balances[i], versions[i] = wm.balanceCache.get(addr, anchor, height)
+		}
+		if balances[i] == nil {
+			misses = append(misses, i)
+		}
+	}
+
+	workers := wm.Config.BalanceConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(misses) {
+		workers = len(misses)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan int)
+		errs = make([]error, len(address))
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				balances[i], errs[i] = wm.getAddressBalance(address[i], anchor)
+			}
+		}()
+	}
+
+	for _, i := range misses {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, i := range misses {
+		if ttl == 0 {
+			continue
+		}
+		if errs[i] != nil {
+			wm.balanceCache.cancel(address[i])
+			continue
+		}
+		wm.balanceCache.set(address[i], anchor, height, versions[i], balances[i], ttl)
+	}
+
+	for _, i := range misses {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+	}
+
+	return balances, nil
+}
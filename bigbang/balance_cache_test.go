@@ -0,0 +1,137 @@
+package bigbang
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetBalanceByAddress_Cache(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	var height int64 = 101
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return atomic.LoadInt64(&height), nil
+	})
+	node.handle("getbalance", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return []interface{}{map[string]interface{}{"avail": "1"}}, nil
+	})
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction("txid_1", "1a", "1b", 3), nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1b")
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	addresses := []string{"1a", "1b", "1c"}
+	query := func(step string, want int) {
+		before := node.callCount("getbalance")
+		balances, err := bs.GetBalanceByAddress(addresses...)
+		if err != nil {
+			t.Fatalf("%s: GetBalanceByAddress failed: %v", step, err)
+		}
+		for i, balance := range balances {
+			if balance.Address != addresses[i] {
+				t.Errorf("%s: balance %d should be of %s, got: %s", step, i, addresses[i], balance.Address)
+			}
+		}
+		if got := node.callCount("getbalance") - before; got != want {
+			t.Errorf("%s: getbalance called %d times, want %d", step, got, want)
+		}
+	}
+
+	query("first", 3)
+	query("cached", 0)
+
+	//扫描到交易后发送方和接收方重新查询
+	result := bs.ExtractTransaction(100, "hash_100", "txid_1", bs.ScanAddressFunc, false)
+	if !result.Success {
+		t.Fatalf("ExtractTransaction failed: %s", result.Reason)
+	}
+	query("invalidated", 2)
+
+	//高度变化后全部重新查询
+	atomic.StoreInt64(&height, 102)
+	query("new height", 3)
+
+	wm.Config.BalanceCacheTTL = 0
+	query("no cache", 3)
+}
+
+func TestGetBalanceByAddress_Concurrency(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	var running, maxRunning int32
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return 101, nil
+	})
+	node.handle("getbalance", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return []interface{}{map[string]interface{}{"avail": "1"}}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+	wm.Config.Anchor = "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+	wm.Config.BalanceConcurrency = 3
+
+	addresses := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		addresses = append(addresses, fmt.Sprintf("1address%d", i))
+	}
+
+	balances, err := wm.Blockscanner.GetBalanceByAddress(addresses...)
+	if err != nil || len(balances) != len(addresses) {
+		t.Fatalf("GetBalanceByAddress failed: %v", err)
+	}
+
+	if maxRunning < 2 || maxRunning > 3 {
+		t.Errorf("balances should be queried concurrently up to 3, got: %d", maxRunning)
+	}
+}
+
+func TestBalanceCache_Versions(t *testing.T) {
+	c := newBalanceCache()
+	balance := &AddrBalance{Address: "1a"}
+
+	//没有缓存和查询的地址失效时不记录版本
+	for i := 0; i < 100; i++ {
+		c.invalidate(fmt.Sprintf("1addr_%d", i))
+	}
+	if len(c.versions) != 0 {
+		t.Errorf("invalidating unknown addresses should not keep versions, got: %d", len(c.versions))
+	}
+
+	//查询期间失效的结果不写入
+	_, version := c.get("1a", "anchor", 10)
+	c.invalidate("1a")
+	c.set("1a", "anchor", 10, version, balance, time.Minute)
+	if cached, _ := c.get("1a", "anchor", 10); cached != nil {
+		t.Errorf("balance loaded before invalidation should not be cached")
+	}
+	c.cancel("1a")
+
+	_, version = c.get("1a", "anchor", 10)
+	c.set("1a", "anchor", 10, version, balance, time.Minute)
+	if cached, _ := c.get("1a", "anchor", 10); cached != balance {
+		t.Errorf("balance should be cached")
+	}
+
+	//查询结束后不保留版本和查询记录
+	c.invalidate("1a")
+	if len(c.versions) != 0 || len(c.loading) != 0 {
+		t.Errorf("versions should be pruned after loads finish, got: %d versions, %d loading", len(c.versions), len(c.loading))
+	}
+}
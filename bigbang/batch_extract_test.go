@@ -0,0 +1,53 @@
+package bigbang
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBatchExtractTransaction_AggregateError(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		if txid == "txid_13" || txid == "txid_42" {
+			return nil, &mockRPCError{Code: -5, Message: "No information available about transaction"}
+		}
+		return testTransaction(txid, "1sender", "1deposit", 3), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+
+	txs := make([]string, 0)
+	for i := 0; i < 3*maxExtractingSize; i++ {
+		txs = append(txs, fmt.Sprintf("txid_%d", i))
+	}
+
+	err := bs.BatchExtractTransaction(10, "block_hash", txs, false)
+	extractErr, ok := err.(*ExtractError)
+	if !ok {
+		t.Fatalf("BatchExtractTransaction should return ExtractError, got: %v", err)
+	}
+
+	if len(extractErr.Failures) != 2 || extractErr.Failures[0].TxID != "txid_13" || extractErr.Failures[1].TxID != "txid_42" {
+		t.Fatalf("failures should list the failed txids in order, got: %v", extractErr)
+	}
+
+	if extractErr.Failures[0].Reason != "[-5]No information available about transaction" {
+		t.Errorf("failure should keep the node error, got: %s", extractErr.Failures[0].Reason)
+	}
+
+	//成功的交易单按区块内顺序通知
+	notified := observer.transactions()
+	if len(notified) != len(txs)-2 {
+		t.Fatalf("notified transactions should be %d, got: %d", len(txs)-2, len(notified))
+	}
+	if notified[0].TxID != "txid_0" || notified[len(notified)-1].TxID != txs[len(txs)-1] {
+		t.Errorf("transactions should be notified in block order")
+	}
+}
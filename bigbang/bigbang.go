@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/log"
@@ -134,6 +135,78 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	fixedFee, _ := c.Int("fixedFee")
 	wm.Config.FixedFee = uint64(fixedFee)
 
+	minFee, err := c.Int("minFee")
+	if err == nil && minFee > 0 {
+		wm.Config.MinFee = uint64(minFee)
+	}
+
+	wm.Config.FeeFromNode, _ = c.Bool("feeFromNode")
+
+	maxRebroadcast, err := c.Int("maxRebroadcast")
+	if err == nil && maxRebroadcast > 0 {
+		wm.Config.MaxRebroadcast = maxRebroadcast
+	}
+
+	maxReorgDepth, err := c.Int("maxReorgDepth")
+	if err == nil && maxReorgDepth > 0 {
+		wm.Config.MaxReorgDepth = uint64(maxReorgDepth)
+	}
+
+	maxRescanAttempts, err := c.Int("maxRescanAttempts")
+	if err == nil && maxRescanAttempts > 0 {
+		wm.Config.MaxRescanAttempts = maxRescanAttempts
+	}
+
+	rescanBackoff, err := c.Int("rescanBackoff")
+	if err == nil && rescanBackoff > 0 {
+		wm.Config.RescanBackoff = time.Duration(rescanBackoff) * time.Second
+	}
+
+	balanceCacheTTL, err := c.Int("balanceCacheTTL")
+	if err == nil && balanceCacheTTL >= 0 {
+		wm.Config.BalanceCacheTTL = time.Duration(balanceCacheTTL) * time.Second
+	}
+
+	balanceConcurrency, err := c.Int("balanceConcurrency")
+	if err == nil && balanceConcurrency > 0 {
+		wm.Config.BalanceConcurrency = balanceConcurrency
+	}
+
+	prefetchWindow, err := c.Int("prefetchWindow")
+	if err == nil && prefetchWindow > 0 {
+		wm.Config.PrefetchWindow = prefetchWindow
+	}
+
+	confirmThreshold, err := c.Int("confirmThreshold")
+	if err == nil && confirmThreshold > 0 {
+		wm.Config.ConfirmThreshold = uint64(confirmThreshold)
+	}
+
+	if confirmMode := c.String("confirmMode"); len(confirmMode) > 0 {
+		if confirmMode != ConfirmModeHold && confirmMode != ConfirmModeTwice {
+			return fmt.Errorf("invalid confirmMode: %s, only hold or twice supported", confirmMode)
+		}
+		wm.Config.ConfirmMode = confirmMode
+	}
+
+	wm.Config.Anchor = c.String("anchor")
+	wm.Config.SubForks = parseSubForks(c.String("subForks"))
+
+	wm.Blockscanner.IsScanMemPool, _ = c.Bool("scanMemPool")
+	wm.Config.AddressIndex, _ = c.Bool("addressIndex")
+	wm.Config.LocalUTXO, _ = c.Bool("localUTXO")
+	wm.Config.BlockListenURL = c.String("blockListenURL")
+	wm.Config.BlockListenSubscribe = c.String("blockListenSubscribe")
+	wm.Config.MetricsListen = c.String("metricsListen")
+
+	wm.Config.SharedAddresses = parseAddressSet(c.String("sharedAddresses"))
+	wm.Config.SuspenseSourceKey = c.String("suspenseSourceKey")
+
+	err = wm.resolveAnchor()
+	if err != nil {
+		return err
+	}
+
 	//数据文件夹
 	wm.Config.makeDataDir()
 	return nil
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//getBlockTransactions 获取区块的全部交易，优先使用getblockdetail，节点不支持则批量gettransaction。
+//批量获取时单笔交易失败不影响其他交易，失败的交易单返回在failures中
+func (bs *BBCBlockScanner) getBlockTransactions(block *Block) ([]*Transaction, []*ExtractFailure, error) {
+
+	if atomic.LoadInt32(&bs.blockDetailUnsupported) == 0 {
+		_, txs, err := bs.wm.Client.getBlockDetail(block.Hash)
+		if err == nil {
+			return txs, nil, nil
+		}
+		if !isMethodNotFound(err) {
+			return nil, nil, err
+		}
+		bs.wm.Log.Std.Info("node does not support getblockdetail, use batch gettransaction instead")
+		atomic.StoreInt32(&bs.blockDetailUnsupported, 1)
+	}
+
+	txs, failures, err := bs.wm.Client.getTransactions(block.TxIDs())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, trx := range txs {
+		trx.BlockHeight = block.Height
+		trx.BlockHash = block.Hash
+	}
+	return txs, failures, nil
+}
+
+//fetchBlockTransactions 获取区块的全部交易，批量获取失败时逐笔获取，获取失败的交易单返回在failures中
+func (bs *BBCBlockScanner) fetchBlockTransactions(block *Block) ([]*Transaction, []*ExtractFailure) {
+
+	txs, failures, err := bs.getBlockTransactions(block)
+	if err == nil {
+		return txs, failures
+	}
+
+	bs.wm.Log.Std.Info("block scanner can not get transactions of block: %s in bulk; unexpected error: %v", block.Hash, err)
+	return bs.getBlockTransactionsOneByOne(block)
+}
+
+//getBlockTransactionsOneByOne 有限数量的线程并发逐笔获取区块的交易，按区块中的顺序返回
+func (bs *BBCBlockScanner) getBlockTransactionsOneByOne(block *Block) ([]*Transaction, []*ExtractFailure) {
+
+	var (
+		txids = block.TxIDs()
+		txs   = make([]*Transaction, len(txids))
+		errs  = make([]error, len(txids))
+		jobs  = make(chan int)
+		wg    sync.WaitGroup
+	)
+
+	workers := maxExtractingSize
+	if len(txids) < workers {
+		workers = len(txids)
+	}
+
+	//每个线程只写入自己负责的结果
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				txs[index], errs[index] = bs.wm.GetTransaction(txids[index])
+			}
+		}()
+	}
+
+	for index := range txids {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+
+	fetched := make([]*Transaction, 0, len(txids))
+	failures := make([]*ExtractFailure, 0)
+	for i, trx := range txs {
+		if errs[i] != nil {
+			failures = append(failures, &ExtractFailure{TxID: txids[i], Reason: errs[i].Error()})
+			continue
+		}
+		trx.BlockHeight = block.Height
+		trx.BlockHash = block.Hash
+		fetched = append(fetched, trx)
+	}
+	return fetched, failures
+}
+
+//fetchBlockByHeight 获取主链指定高度的区块及其全部交易，供预取器在窗口内并发获取
+func (bs *BBCBlockScanner) fetchBlockByHeight(height uint64) (*Block, error) {
+
+	block, err := bs.wm.Client.getBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	block.txs, block.failures = bs.fetchBlockTransactions(block)
+	block.txsFetched = true
+	return block, nil
+}
+
+//blockTransactions 区块的全部交易，预取时已获取的直接使用
+func (bs *BBCBlockScanner) blockTransactions(block *Block) ([]*Transaction, []*ExtractFailure) {
+	if block.txsFetched {
+		return block.txs, block.failures
+	}
+	return bs.fetchBlockTransactions(block)
+}
+
+//extractBlock 提取区块的交易单，批量获取失败时逐笔获取
+func (bs *BBCBlockScanner) extractBlock(block *Block) error {
+
+	txs, failures := bs.blockTransactions(block)
+
+	return bs.extractTransactions(bs.blockFork(block), block.Height, block.Hash, txs, failures)
+}
+
+//extractTransactions 提取已获取的区块交易单并按顺序通知，获取失败的交易单记录未扫记录等待重扫
+func (bs *BBCBlockScanner) extractTransactions(fork string, height uint64, hash string, txs []*Transaction, failures []*ExtractFailure) error {
+
+	var (
+		extractErr = &ExtractError{BlockHeight: height}
+		extracted  = make([]string, 0) //已提取的交易单
+	)
+
+	for _, trx := range txs {
+		result := ExtractResult{
+			BlockHeight: height,
+			TxID:        trx.TxID,
+			extractData: make(map[string]*openwallet.TxExtractData),
+			Success:     true,
+			fork:        fork,
+			blockHash:   hash,
+		}
+
+		bs.extractTransaction(trx, &result, bs.ScanAddressFunc)
+
+		if result.Success && len(result.extractData) > 0 {
+			extracted = append(extracted, trx.TxID)
+		}
+
+		if !bs.notifyExtractResult(height, &result) {
+			extractErr.add(trx.TxID, result.Reason)
+		}
+	}
+
+	for _, failure := range failures {
+		result := ExtractResult{
+			BlockHeight: height,
+			TxID:        failure.TxID,
+			Success:     false,
+			Reason:      failure.Reason,
+			fork:        fork,
+			blockHash:   hash,
+		}
+		bs.notifyExtractResult(height, &result)
+		extractErr.add(failure.TxID, failure.Reason)
+	}
+
+	bs.saveExtractedTxIDs(fork, height, hash, extracted)
+
+	if len(extractErr.Failures) > 0 {
+		return extractErr
+	}
+	return nil
+}
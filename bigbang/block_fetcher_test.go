@@ -0,0 +1,146 @@
+package bigbang
+
+import (
+	"testing"
+)
+
+func TestExtractBlock_BlockDetail(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+
+	node.handle("getblockdetail", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{
+			"hash":     "block_hash",
+			"hashPrev": "prev_hash",
+			"height":   10,
+			"txmint":   testTransaction("txid_mint", "", "1miner", 1)["transaction"],
+			"tx": []interface{}{
+				testTransaction("txid_1", "1sender", "1deposit", 1)["transaction"],
+				testTransaction("txid_2", "1sender", "1other", 1)["transaction"],
+				testTransaction("txid_3", "1sender", "1deposit", 1)["transaction"],
+			},
+		}, nil
+	})
+
+	block := &Block{Hash: "block_hash", Height: 10, Transactions: []string{"txid_1", "txid_2", "txid_3"}}
+	if err := wm.Blockscanner.extractBlock(block); err != nil {
+		t.Fatalf("extractBlock failed, unexpected error: %v", err)
+	}
+
+	txs := observer.transactions()
+	if len(txs) != 2 || txs[0].TxID != "txid_1" || txs[1].TxID != "txid_3" || txs[0].BlockHeight != 10 {
+		t.Errorf("deposits should be extracted in block order, got: %d", len(txs))
+	}
+
+	if node.callCount("getblockdetail") != 1 || node.callCount("gettransaction") != 0 {
+		t.Errorf("block should be fetched with one getblockdetail call")
+	}
+}
+
+func TestExtractBlock_BatchTransactions(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		if txid == "txid_2" {
+			return testTransaction(txid, "1sender", "1other", 1), nil
+		}
+		return testTransaction(txid, "1sender", "1deposit", 1), nil
+	})
+
+	block := &Block{Hash: "block_hash", Height: 10, Transactions: []string{"txid_1", "txid_2", "txid_3"}}
+	for i := 0; i < 2; i++ {
+		if err := wm.Blockscanner.extractBlock(block); err != nil {
+			t.Fatalf("extractBlock failed, unexpected error: %v", err)
+		}
+	}
+
+	if len(observer.transactions()) != 4 {
+		t.Errorf("deposits should be extracted from batch response, got: %d", len(observer.transactions()))
+	}
+
+	//不支持getblockdetail只探测一次
+	if n := node.callCount("getblockdetail"); n != 1 {
+		t.Errorf("getblockdetail should be probed once, got: %d", n)
+	}
+}
+
+func TestExtractBlock_BatchItemError(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	dai := newTestBlockchainDAI()
+	wm.Blockscanner.SetBlockchainDAI(dai)
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		if txid == "txid_2" {
+			return nil, &mockRPCError{Code: -5, Message: "No information available about transaction"}
+		}
+		return testTransaction(txid, "1sender", "1deposit", 1), nil
+	})
+
+	block := &Block{Hash: "block_hash", Height: 10, Transactions: []string{"txid_1", "txid_2", "txid_3"}}
+	err := wm.Blockscanner.extractBlock(block)
+	extractErr, ok := err.(*ExtractError)
+	if !ok || len(extractErr.Failures) != 1 || extractErr.Failures[0].TxID != "txid_2" {
+		t.Fatalf("only the failed tx should be reported, got: %v", err)
+	}
+
+	//批量中单笔失败不影响其他交易，也不逐笔重新获取整个区块
+	txs := observer.transactions()
+	if len(txs) != 2 || txs[0].TxID != "txid_1" || txs[1].TxID != "txid_3" {
+		t.Errorf("other txs in the batch should be extracted, got: %d", len(txs))
+	}
+	if n := node.callCount("gettransaction"); n != 3 {
+		t.Errorf("failed tx should not trigger per-tx fallback, got calls: %d", n)
+	}
+	if len(dai.unscans) != 1 || dai.unscans[0].TxID != "txid_2" || dai.unscans[0].BlockHeight != 10 {
+		t.Errorf("failed tx should be recorded as unscan, got: %v", dai.unscans)
+	}
+}
+
+func TestExtractBlock_StakeReward(t *testing.T) {
+	for _, batch := range []bool{true, false} {
+		node := newMockNode()
+		node.batchUnsupported = !batch
+
+		wm, observer := newTestScanner(t, node, "1miner", "1deposit")
+
+		node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+			txid := params["txid"].(string)
+			if txid == "txid_mint" {
+				tx := testTransaction(txid, "", "1miner", 1)
+				tx["transaction"].(map[string]interface{})["type"] = TxTypeNameStake
+				return tx, nil
+			}
+			return testTransaction(txid, "1sender", "1deposit", 1), nil
+		})
+
+		//出块奖励交易只在txmint中
+		block := &Block{Hash: "block_hash", Height: 10, TransactionMerkleRoot: "txid_mint", Transactions: []string{"txid_1"}}
+		if err := wm.Blockscanner.extractBlock(block); err != nil {
+			t.Fatalf("batch: %v, extractBlock failed, unexpected error: %v", batch, err)
+		}
+
+		txs := observer.transactions()
+		if len(txs) != 2 || txs[0].TxID != "txid_mint" || txs[1].TxID != "txid_1" {
+			t.Fatalf("batch: %v, mint and deposit should be extracted, got: %d", batch, len(txs))
+		}
+		if dpos := txs[0].GetExtParam().Get("dpos").String(); dpos != DPoSActionStake || len(txs[0].From) != 0 {
+			t.Errorf("batch: %v, mint should be extracted as stake reward, got dpos: %s, from: %v", batch, dpos, txs[0].From)
+		}
+
+		closeTestWalletManager(wm)
+		node.Close()
+	}
+}
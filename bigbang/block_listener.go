@@ -0,0 +1,203 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	listenerPollInterval      = time.Minute      //推送连接正常时的兜底轮询间隔
+	listenerReconnectInterval = 5 * time.Second  //推送断开后的首次重连间隔
+	listenerMaxReconnect      = 2 * time.Minute  //重连间隔上限
+	listenerHandshakeTimeout  = 10 * time.Second //连接超时
+)
+
+//blockListener 新区块推送监听，连接websocket，收到任何消息即触发扫描，断开后自动重连
+type blockListener struct {
+	wm        *WalletManager
+	url       string
+	subscribe string //连接后发送的订阅消息，为空则不发送
+	onBlock   func()
+	reconnect time.Duration //首次重连间隔
+	connected int32
+	trigger   chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+//newBlockListener 创建新区块推送监听
+func newBlockListener(wm *WalletManager, url, subscribe string, onBlock func()) *blockListener {
+	return &blockListener{
+		wm:        wm,
+		url:       url,
+		subscribe: subscribe,
+		onBlock:   onBlock,
+		reconnect: listenerReconnectInterval,
+	}
+}
+
+//Start 开始监听，已在监听则忽略
+func (l *blockListener) Start() {
+	if l.stop != nil {
+		return
+	}
+
+	l.stop = make(chan struct{})
+	l.trigger = make(chan struct{}, 1)
+
+	l.wg.Add(2)
+	go l.listen(l.stop)
+	go l.consume(l.stop)
+}
+
+//Stop 停止监听，等待正在执行的扫描完成
+func (l *blockListener) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	l.wg.Wait()
+	l.stop = nil
+}
+
+//Connected 推送连接是否正常
+func (l *blockListener) Connected() bool {
+	return atomic.LoadInt32(&l.connected) == 1
+}
+
+//listen 保持推送连接，断开后按指数间隔重连
+func (l *blockListener) listen(stop chan struct{}) {
+	defer l.wg.Done()
+
+	dialer := websocket.Dialer{HandshakeTimeout: listenerHandshakeTimeout}
+	reconnect := l.reconnect
+
+	for {
+		conn, _, err := dialer.Dial(l.url, nil)
+		if err == nil && len(l.subscribe) > 0 {
+			err = conn.WriteMessage(websocket.TextMessage, []byte(l.subscribe))
+			if err != nil {
+				conn.Close()
+			}
+		}
+
+		if err != nil {
+			l.wm.Log.Std.Info("block listener can not connect: %s, use polling instead; unexpected error: %v", l.url, err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(reconnect):
+			}
+			reconnect *= 2
+			if reconnect > listenerMaxReconnect {
+				reconnect = listenerMaxReconnect
+			}
+			continue
+		}
+
+		l.wm.Log.Std.Info("block listener connected: %s", l.url)
+		reconnect = l.reconnect
+		atomic.StoreInt32(&l.connected, 1)
+
+		//连接期间可能错过推送，连接后先扫描一次
+		l.notify()
+
+		closed := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+				conn.Close()
+			case <-closed:
+			}
+		}()
+
+		for {
+			_, _, err = conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			l.notify()
+		}
+
+		close(closed)
+		conn.Close()
+		atomic.StoreInt32(&l.connected, 0)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		l.wm.Log.Std.Info("block listener disconnected, use polling until reconnected; unexpected error: %v", err)
+	}
+}
+
+//notify 触发扫描，扫描未开始前的多次推送合并为一次
+func (l *blockListener) notify() {
+	select {
+	case l.trigger <- struct{}{}:
+	default:
+	}
+}
+
+//consume 收到推送后执行扫描
+func (l *blockListener) consume(stop chan struct{}) {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-l.trigger:
+			l.onBlock()
+		}
+	}
+}
+
+//pollBlockTask 定时扫描任务，推送连接正常时只做低频兜底轮询，断开后按定时任务间隔轮询
+func (bs *BBCBlockScanner) pollBlockTask() {
+	if bs.listener != nil && bs.listener.Connected() {
+		lastScanTime := time.Unix(atomic.LoadInt64(&bs.lastScanTime), 0)
+		if time.Since(lastScanTime) < listenerPollInterval {
+			return
+		}
+	}
+	bs.ScanBlockTask()
+}
+
+//startBlockListener 配置了推送地址时开始监听新区块
+func (bs *BBCBlockScanner) startBlockListener() {
+	if len(bs.wm.Config.BlockListenURL) == 0 {
+		return
+	}
+	if bs.listener == nil {
+		bs.listener = newBlockListener(bs.wm, bs.wm.Config.BlockListenURL, bs.wm.Config.BlockListenSubscribe, bs.ScanBlockTask)
+	}
+	bs.listener.Start()
+}
+
+//stopBlockListener 停止监听新区块
+func (bs *BBCBlockScanner) stopBlockListener() {
+	if bs.listener != nil {
+		bs.listener.Stop()
+	}
+}
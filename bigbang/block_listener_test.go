@@ -0,0 +1,114 @@
+package bigbang
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBlockListener_Reconnect(t *testing.T) {
+	var (
+		upgrader    websocket.Upgrader
+		connections int32
+		subscribed  = make(chan string, 2)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		subscribed <- string(msg)
+
+		//第一次连接推送一个区块后断开，第二次连接保持
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"height":1}`))
+		if atomic.AddInt32(&connections, 1) == 1 {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	node := newMockNode()
+	defer node.Close()
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	var scans int32
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	listener := newBlockListener(wm, url, `{"subscribe":"block"}`, func() {
+		atomic.AddInt32(&scans, 1)
+	})
+	listener.reconnect = 10 * time.Millisecond
+	listener.Start()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-subscribed:
+			if msg != `{"subscribe":"block"}` {
+				t.Errorf("unexpected subscribe message: %s", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("listener should reconnect after the stream drops")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for (!listener.Connected() || atomic.LoadInt32(&scans) == 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !listener.Connected() {
+		t.Errorf("listener should be connected")
+	}
+	if atomic.LoadInt32(&scans) == 0 {
+		t.Errorf("new block should trigger scan")
+	}
+
+	listener.Stop()
+	if listener.Connected() {
+		t.Errorf("listener should be disconnected after stop")
+	}
+}
+
+func TestPollBlockTask_SkipWhenConnected(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+	bs.listener = newBlockListener(wm, "", "", bs.ScanBlockTask)
+
+	//推送连接正常且刚扫描过，定时任务不再扫描
+	atomic.StoreInt32(&bs.listener.connected, 1)
+	atomic.StoreInt64(&bs.lastScanTime, time.Now().Unix()-1)
+	scanned := atomic.LoadInt64(&bs.lastScanTime)
+	bs.pollBlockTask()
+	if atomic.LoadInt64(&bs.lastScanTime) != scanned {
+		t.Errorf("poll should be skipped while the stream is connected")
+	}
+
+	//推送断开后恢复轮询
+	atomic.StoreInt32(&bs.listener.connected, 0)
+	bs.pollBlockTask()
+	if atomic.LoadInt64(&bs.lastScanTime) == scanned {
+		t.Errorf("poll should scan while the stream is disconnected")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+//prefetchResult 预取的区块
+type prefetchResult struct {
+	block *Block
+	err   error
+}
+
+//blockPrefetcher 区块预取器，扫描位置之后的区块及其交易在窗口内并发获取，按高度顺序读取
+type blockPrefetcher struct {
+	fetch   func(height uint64) (*Block, error)
+	window  int
+	next    uint64 //下一个读取的高度
+	end     uint64 //本轮预取的最大高度
+	pending chan chan prefetchResult
+	stop    chan struct{}
+	sem     chan struct{} //限制并发获取数，重新预取时仍包含上一轮未完成的获取
+}
+
+//newBlockPrefetcher 创建区块预取器，window小于等于1时不预取
+func newBlockPrefetcher(window int, fetch func(height uint64) (*Block, error)) *blockPrefetcher {
+	p := &blockPrefetcher{
+		fetch:  fetch,
+		window: window,
+	}
+	if window > 1 {
+		p.sem = make(chan struct{}, window)
+	}
+	return p
+}
+
+//Get 按顺序获取指定高度的区块，高度不连续时（如分叉回退）重新开始预取
+func (p *blockPrefetcher) Get(height, maxHeight uint64) (*Block, error) {
+
+	if p.window <= 1 {
+		return p.fetch(height)
+	}
+
+	if p.pending == nil || height != p.next || height > p.end {
+		p.start(height, maxHeight)
+	}
+
+	result := <-<-p.pending
+	p.next++
+
+	if result.err != nil {
+		//出错后丢弃窗口内的区块，下次重新获取
+		p.Stop()
+		return nil, result.err
+	}
+	return result.block, nil
+}
+
+//start 从指定高度开始预取至最大高度
+func (p *blockPrefetcher) start(from, to uint64) {
+
+	p.Stop()
+
+	p.next = from
+	p.end = to
+	p.pending = make(chan chan prefetchResult, p.window-1)
+	p.stop = make(chan struct{})
+
+	go func(pending chan chan prefetchResult, stop chan struct{}) {
+		for height := from; height <= to; height++ {
+			ch := make(chan prefetchResult, 1)
+			select {
+			case pending <- ch:
+			case <-stop:
+				return
+			}
+			select {
+			case p.sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func(h uint64) {
+				block, err := p.fetch(h)
+				<-p.sem
+				ch <- prefetchResult{block: block, err: err}
+			}(height)
+		}
+	}(p.pending, p.stop)
+}
+
+//Stop 停止预取
+func (p *blockPrefetcher) Stop() {
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.pending = nil
+	p.stop = nil
+}
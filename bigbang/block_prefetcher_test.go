@@ -0,0 +1,171 @@
+package bigbang
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestBlockPrefetcher_Order(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		inFlight    int
+		maxInFlight int
+	)
+
+	window := 4
+	fetch := func(height uint64) (*Block, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		//高度越低返回越慢，验证仍按顺序读取
+		time.Sleep(time.Duration(30-height%30) * time.Millisecond / 10)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if height == 25 {
+			return nil, errors.New("fetch failed")
+		}
+		return &Block{Height: height}, nil
+	}
+
+	p := newBlockPrefetcher(window, fetch)
+	defer p.Stop()
+
+	for height := uint64(1); height <= 20; height++ {
+		block, err := p.Get(height, 30)
+		if err != nil || block.Height != height {
+			t.Fatalf("Get height: %d, got: %v, error: %v", height, block, err)
+		}
+	}
+
+	//分叉回退后从新高度重新预取
+	for height := uint64(18); height <= 24; height++ {
+		block, err := p.Get(height, 30)
+		if err != nil || block.Height != height {
+			t.Fatalf("Get height: %d after rollback, got: %v, error: %v", height, block, err)
+		}
+	}
+
+	if _, err := p.Get(25, 30); err == nil {
+		t.Errorf("fetch error should be returned")
+	}
+
+	block, err := p.Get(26, 30)
+	if err != nil || block.Height != 26 {
+		t.Errorf("Get height: 26 after error, got: %v, error: %v", block, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight > window {
+		t.Errorf("blocks in flight should be bounded by window: %d, got: %d", window, maxInFlight)
+	}
+}
+
+func TestScanBlockTask_Prefetch(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	chain := &testChain{hashes: []string{"h0"}}
+	for i := 1; i <= 30; i++ {
+		chain.hashes = append(chain.hashes, fmt.Sprintf("h%d", i))
+	}
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+	chain.setup(node)
+
+	dai := newTestBlockchainDAI()
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Height: 1, Hash: "h1"})
+	wm.Blockscanner.SetBlockchainDAI(dai)
+	wm.Blockscanner.Scanning = true
+	wm.Config.PrefetchWindow = 5
+
+	wm.Blockscanner.ScanBlockTask()
+
+	if dai.current.Height != 30 || dai.current.Hash != chain.hashes[30] {
+		t.Fatalf("scanner should catch up to height 30, got: %d", dai.current.Height)
+	}
+
+	//起点区块和29个新区块
+	if len(dai.saved) != 30 {
+		t.Fatalf("start block and 29 new blocks should be saved, got: %v", dai.saved)
+	}
+	for i, height := range dai.saved {
+		if height != uint64(i+1) {
+			t.Fatalf("blocks should be committed in height order, got: %d at %d", height, i)
+		}
+	}
+}
+
+func TestScanBlockTask_PrefetchTransactions(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	chain := &testChain{hashes: []string{"h0"}, txs: make(map[string][]string)}
+	for i := 1; i <= 10; i++ {
+		hash := fmt.Sprintf("h%d", i)
+		chain.hashes = append(chain.hashes, hash)
+		chain.txs[hash] = []string{fmt.Sprintf("txid_%d", i)}
+	}
+
+	var (
+		mu          sync.Mutex
+		inFlight    int
+		maxInFlight int
+	)
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return testTransaction(params["txid"].(string), "1sender", "1deposit", 3), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	chain.setup(node)
+
+	dai := newTestBlockchainDAI()
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Height: 1, Hash: "h1"})
+	wm.Blockscanner.SetBlockchainDAI(dai)
+	wm.Blockscanner.Scanning = true
+	wm.Config.PrefetchWindow = 5
+
+	wm.Blockscanner.ScanBlockTask()
+
+	//区块的交易在预取窗口内并发获取，仍按高度顺序通知
+	txs := observer.transactions()
+	if len(txs) != 9 {
+		t.Fatalf("deposits of 9 new blocks should be notified, got: %d", len(txs))
+	}
+	for i, tx := range txs {
+		if tx.BlockHeight != uint64(i+2) {
+			t.Fatalf("deposits should be notified in height order, got: %d at %d", tx.BlockHeight, i)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight < 2 {
+		t.Errorf("transactions of prefetched blocks should be fetched concurrently, got: %d", maxInFlight)
+	}
+}
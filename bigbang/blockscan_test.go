@@ -53,7 +53,7 @@ func TestGetBlockHeight(t *testing.T) {
 }
 
 func TestGetLocalNewBlock(t *testing.T) {
-	height, hash, _ := tw.GetLocalNewBlock()
+	height, hash, _ := tw.Blockscanner.GetLocalNewBlock()
 	t.Logf("GetLocalBlockHeight height = %d \n", height)
 	t.Logf("GetLocalBlockHeight hash = %v \n", hash)
 }
@@ -268,7 +268,7 @@ func TestONTBlockScanner_GetTransactionsByAddress(t *testing.T) {
 }
 
 func TestGetLocalBlock(t *testing.T) {
-	db, err := storm.Open(filepath.Join(tw.Config.dbPath, tw.Config.BlockchainFile))
+	db, err := storm.Open(filepath.Join(tw.Config.dbPath, "blockchain.db"))
 	if err != nil {
 		return
 	}
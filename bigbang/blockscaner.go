@@ -18,16 +18,15 @@ package bigbang
 import (
 	"errors"
 	"fmt"
-	"github.com/asdine/storm"
-	"net/url"
+	"math/big"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/blocktree/openwallet/common"
 	"github.com/blocktree/openwallet/openwallet"
-	gosocketio "github.com/graarh/golang-socketio"
-	"github.com/graarh/golang-socketio/transport"
 	"github.com/shopspring/decimal"
 )
 
@@ -40,12 +39,21 @@ type BBCBlockScanner struct {
 	*openwallet.BlockScannerBase
 
 	CurrentBlockHeight   uint64             //当前区块高度
-	extractingCH         chan struct{}      //扫描工作令牌
 	wm                   *WalletManager     //钱包管理者
 	IsScanMemPool        bool               //是否扫描交易池
 	RescanLastBlockCount uint64             //重扫上N个区块数量
-	socketIO             *gosocketio.Client //socketIO客户端
 	RPCServer            int
+	blockDetailUnsupported int32 //节点不支持getblockdetail
+	tipHeights           sync.Map //主链和子链的最新高度，用于计算确认数
+	memoScanTargetFunc   MemoScanTargetFunc //共享地址按memo查找源标识
+	reorgObservers       map[ReorgObserver]bool //分叉观察者
+	reorgHalted          map[string]bool        //分叉无法自动回退而停止扫描的分支，主链为空
+	reorgMu              sync.RWMutex
+	listener             *blockListener //新区块推送监听
+	scanMu               sync.Mutex     //扫描任务锁
+	lastScanTime         int64          //最近一次扫描的时间
+	stats                *scanStats     //扫描统计
+	metricsServer        *http.Server   //Prometheus指标服务
 }
 
 //ExtractResult 扫描完成的提取结果
@@ -54,6 +62,36 @@ type ExtractResult struct {
 	TxID        string
 	BlockHeight uint64
 	Success     bool
+	Reason      string     //提取失败的原因
+	memPool     bool       //是否交易池中未确认的交易
+	fork        string     //所属子链，主链为空
+	blockHash   string     //所在区块hash，记录未扫记录时保存
+	readOnly    bool       //只提取交易数据，不写入输出、UTXO和余额缓存
+	pool        *MemPoolTx //交易池中的交易花费和转入已监听地址的输出
+}
+
+//ExtractFailure 提取或通知失败的交易单
+type ExtractFailure struct {
+	TxID   string
+	Reason string
+}
+
+//ExtractError 区块内提取失败的交易单汇总
+type ExtractError struct {
+	BlockHeight uint64
+	Failures    []*ExtractFailure
+}
+
+func (e *ExtractError) add(txid, reason string) {
+	e.Failures = append(e.Failures, &ExtractFailure{TxID: txid, Reason: reason})
+}
+
+func (e *ExtractError) Error() string {
+	details := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		details = append(details, fmt.Sprintf("%s: %s", f.TxID, f.Reason))
+	}
+	return fmt.Sprintf("block height: %d extract %d transactions failed: %s", e.BlockHeight, len(e.Failures), strings.Join(details, "; "))
 }
 
 //SaveResult 保存结果
@@ -69,13 +107,13 @@ func NewBBCBlockScanner(wm *WalletManager) *BBCBlockScanner {
 		BlockScannerBase: openwallet.NewBlockScannerBase(),
 	}
 
-	bs.extractingCH = make(chan struct{}, maxExtractingSize)
 	bs.wm = wm
+	bs.stats = newScanStats()
 	bs.IsScanMemPool = false
 	bs.RescanLastBlockCount = 0
 
-	//设置扫描任务
-	bs.SetTask(bs.ScanBlockTask)
+	//设置扫描任务，开启推送监听时定时任务作为兜底轮询
+	bs.SetTask(bs.pollBlockTask)
 
 	return &bs
 }
@@ -94,22 +132,48 @@ func (bs *BBCBlockScanner) SetRescanBlockHeight(height uint64) error {
 
 	bs.wm.Blockscanner.SaveLocalNewBlock(height, hash)
 
+	//人工重设扫描高度后恢复主链扫描
+	bs.ClearReorgHalt("")
+
 	return nil
 }
 
 //ScanBlockTask 扫描任务
 func (bs *BBCBlockScanner) ScanBlockTask() {
 
+	//定时轮询和新区块推送可能同时触发，同一时间只执行一个扫描
+	bs.scanMu.Lock()
+	defer bs.scanMu.Unlock()
+	atomic.StoreInt64(&bs.lastScanTime, time.Now().Unix())
+
 	//获取本地区块高度
 	blockHeader, err := bs.GetScannedBlockHeader()
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not get new block height; unexpected error: %v", err)
+		bs.stats.recordError(err)
 		return
 	}
 
 	currentHeight := blockHeader.Height
 	currentHash := blockHeader.Hash
-	var previousHeight uint64 = 0
+
+	//分叉超出自动回退范围，停止扫描等待人工处理
+	if bs.isReorgHalted("") {
+		bs.wm.Log.Std.Info("block scanner is halted by block reorg on height: %d, please rescan manually", currentHeight)
+		return
+	}
+
+	//记录扫描起点区块，分叉时用于校验共同祖先
+	err = bs.saveForkStartBlock("", blockHeader)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not save start block; unexpected error: %v", err)
+		bs.stats.recordError(err)
+		return
+	}
+
+	//追块时在窗口内并发预取区块，仍按高度顺序提取
+	prefetcher := newBlockPrefetcher(bs.wm.Config.PrefetchWindow, bs.fetchBlockByHeight)
+	defer prefetcher.Stop()
 
 	for {
 
@@ -123,9 +187,12 @@ func (bs *BBCBlockScanner) ScanBlockTask() {
 		if err != nil {
 			//下一个高度找不到会报异常
 			bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
+			bs.stats.recordError(err)
 			break
 		}
 
+		bs.setTipHeight("", maxHeight)
+
 		//是否已到最新高度
 		if currentHeight >= maxHeight {
 			bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
@@ -136,83 +203,50 @@ func (bs *BBCBlockScanner) ScanBlockTask() {
 		currentHeight = currentHeight + 1
 		bs.wm.Log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		localBlock, err := bs.wm.Client.getBlockByHeight(currentHeight)
+		localBlock, err := prefetcher.Get(currentHeight, maxHeight)
 		if err != nil {
 			bs.wm.Log.Std.Info("getBlockByHeight failed; unexpected error: %v", err)
+			bs.stats.recordError(err)
 			break
 		}
 
-		isFork := false
-
 		//判断hash是否上一区块的hash
 		if currentHash != localBlock.PrevBlockHash {
-			previousHeight = currentHeight - 1
 			bs.wm.Log.Std.Info("block has been fork on height: %d.", currentHeight)
-			bs.wm.Log.Std.Info("block height: %d local hash = %s ", previousHeight, currentHash)
-			bs.wm.Log.Std.Info("block height: %d mainnet hash = %s ", previousHeight, localBlock.PrevBlockHash)
-
-			bs.wm.Log.Std.Info("delete recharge records on block height: %d.", previousHeight)
-
-			//删除上一区块链的所有充值记录
-			//bs.DeleteRechargesByHeight(currentHeight - 1)
-			forkBlock, _ := bs.GetLocalBlock(uint32(previousHeight))
-			//删除上一区块链的未扫记录
-			bs.wm.Blockscanner.DeleteUnscanRecord(uint32(previousHeight))
-			currentHeight = previousHeight - 1 //倒退2个区块重新扫描
-			if currentHeight <= 0 {
-				currentHeight = 1
-			}
+			bs.wm.Log.Std.Info("block height: %d local hash = %s ", currentHeight-1, currentHash)
+			bs.wm.Log.Std.Info("block height: %d mainnet hash = %s ", currentHeight-1, localBlock.PrevBlockHash)
 
-			localBlock, err = bs.GetLocalBlock(uint32(currentHeight))
-			if err != nil && err != storm.ErrNotFound {
-				bs.wm.Log.Std.Error("block scanner can not get local block; unexpected error: %v", err)
+			//回退到共同祖先区块，从新分支继续扫描
+			ancestor, err := bs.rollbackToCommonAncestor(currentHeight-1, currentHash)
+			if err != nil {
+				bs.wm.Log.Std.Error("block scanner can not rollback fork; unexpected error: %v", err)
+				bs.stats.recordError(err)
 				break
-			} else if err == storm.ErrNotFound {
-				//查找core钱包的RPC
-				bs.wm.Log.Info("block scanner prev block height:", currentHeight)
-
-				localBlock, err = bs.wm.Client.getBlockByHeight(currentHeight)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
-
 			}
 
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+			currentHeight = ancestor.Height
+			currentHash = ancestor.Hash
 
 			bs.wm.Log.Std.Info("rescan block on height: %d, hash: %s .", currentHeight, currentHash)
+			continue
+		}
 
-			//重新记录一个新扫描起点
-			bs.wm.Blockscanner.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
-
-			isFork = true
-
-			if forkBlock != nil {
-				//通知分叉区块给观测者，异步处理
-				bs.newBlockNotify(forkBlock, isFork)
-			}
-
-		} else {
-
-			err = bs.BatchExtractTransaction(localBlock.Height, localBlock.Hash, localBlock.Transactions, false)
-			if err != nil {
-				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-			}
-
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+		err = bs.extractBlock(localBlock)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+			bs.stats.recordError(err)
+		}
 
-			//保存本地新高度
-			bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
-			bs.SaveLocalBlock(localBlock)
+		//重置当前区块的hash
+		currentHash = localBlock.Hash
 
-			isFork = false
-		}
+		//保存本地新高度
+		bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
+		bs.SaveLocalBlock(localBlock)
+		bs.stats.recordBlock(currentHeight, len(localBlock.Transactions))
 
 		//通知新区块给观测者，异步处理
-		bs.newBlockNotify(localBlock, isFork)
+		bs.newBlockNotify(localBlock, false)
 	}
 
 	//重扫前N个块，为保证记录找到
@@ -220,6 +254,12 @@ func (bs *BBCBlockScanner) ScanBlockTask() {
 		bs.scanBlock(i)
 	}
 
+	//扫描已配置的子链
+	bs.scanSubForks()
+
+	//通知已达到确认数的充值
+	bs.releaseConfirmedExtracts()
+
 	if bs.IsScanMemPool {
 		//扫描交易内存池
 		bs.ScanTxMemPool()
@@ -228,6 +268,9 @@ func (bs *BBCBlockScanner) ScanBlockTask() {
 	//重扫失败区块
 	bs.RescanFailedRecord()
 
+	//检查已广播交易的状态
+	bs.wm.TxOutbox.Check()
+
 }
 
 //ScanBlock 扫描指定高度区块
@@ -258,7 +301,7 @@ func (bs *BBCBlockScanner) scanBlock(height uint64) (*Block, error) {
 
 	bs.wm.Log.Std.Info("block scanner scanning height: %d ...", block.Height)
 
-	err = bs.BatchExtractTransaction(block.Height, block.Hash, block.Transactions, false)
+	err = bs.extractBlock(block)
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 	}
@@ -278,6 +321,13 @@ func (bs *BBCBlockScanner) ScanTxMemPool() {
 		return
 	}
 
+	//已提取过的交易不再重复通知
+	txIDsInMemPool, err = bs.wm.filterMemPoolTxIDs(txIDsInMemPool)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not filter mempool data; unexpected error: %v", err)
+		return
+	}
+
 	if len(txIDsInMemPool) == 0 {
 		bs.wm.Log.Std.Info("no transactions in mempool ...")
 		return
@@ -290,65 +340,6 @@ func (bs *BBCBlockScanner) ScanTxMemPool() {
 
 }
 
-//rescanFailedRecord 重扫失败记录
-func (bs *BBCBlockScanner) RescanFailedRecord() {
-
-	var (
-		blockMap = make(map[uint64][]string)
-	)
-
-	list, err := bs.GetUnscanRecords()
-	if err != nil {
-		bs.wm.Log.Std.Info("block scanner can not get rescan data; unexpected error: %v", err)
-	}
-
-	//组合成批处理
-	for _, r := range list {
-
-		if _, exist := blockMap[r.BlockHeight]; !exist {
-			blockMap[r.BlockHeight] = make([]string, 0)
-		}
-
-		if len(r.TxID) > 0 {
-			arr := blockMap[r.BlockHeight]
-			arr = append(arr, r.TxID)
-
-			blockMap[r.BlockHeight] = arr
-		}
-	}
-
-	for height, txs := range blockMap {
-
-		var hash string
-
-		if height != 0 {
-			bs.wm.Log.Std.Info("block scanner rescanning height: %d ...", height)
-
-			if len(txs) == 0 {
-
-				block, err := bs.wm.Client.getBlockByHeight(height)
-				if err != nil {
-					bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
-					continue
-				}
-
-				txs = block.Transactions
-			}
-
-			err = bs.BatchExtractTransaction(height, hash, txs, false)
-			if err != nil {
-				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-				continue
-			}
-		}
-		//删除未扫记录
-		bs.wm.Blockscanner.DeleteUnscanRecord(uint32(height))
-	}
-
-	//删除未没有找到交易记录的重扫记录
-	bs.wm.Blockscanner.DeleteUnscanRecordNotFindTX()
-}
-
 //newBlockNotify 获得新区块后，通知给观测者
 func (bs *BBCBlockScanner) newBlockNotify(block *Block, isFork bool) {
 	header := block.BlockHeader()
@@ -357,122 +348,162 @@ func (bs *BBCBlockScanner) newBlockNotify(block *Block, isFork bool) {
 }
 
 //BatchExtractTransaction 批量提取交易单
-//bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
+//有限数量的线程并发提取，提取结果按交易单顺序通知，失败的交易单汇总为ExtractError返回
 func (bs *BBCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []string, memPool bool) error {
+	return bs.batchExtractTransaction("", blockHeight, blockHash, txs, memPool)
+}
 
-	var (
-		quit       = make(chan struct{})
-		done       = 0 //完成标记
-		failed     = 0
-		shouldDone = len(txs) //需要完成的总数
-	)
+//batchExtractTransaction 批量提取分支的交易单，fork为空则为主链
+func (bs *BBCBlockScanner) batchExtractTransaction(fork string, blockHeight uint64, blockHash string, txs []string, memPool bool) error {
 
 	if len(txs) == 0 {
 		return nil
 	}
 
-	//生产通道
-	producer := make(chan ExtractResult)
-	defer close(producer)
-
-	//消费通道
-	worker := make(chan ExtractResult)
-	defer close(worker)
-
-	//保存工作
-	saveWork := func(height uint64, result chan ExtractResult) {
-		//回收创建的地址
-		for gets := range result {
+	var (
+		results   = make([]ExtractResult, len(txs)) //每个交易单的提取结果
+		extracted = make([]string, 0)               //已提取的交易单
+		jobs      = make(chan int)
+		wg        sync.WaitGroup
+	)
 
-			if gets.Success {
+	workers := maxExtractingSize
+	if len(txs) < workers {
+		workers = len(txs)
+	}
 
-				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
-				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
-				if notifyErr != nil {
-					failed++ //标记保存失败数
-					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
-				}
-			} else {
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "",bs.wm.Symbol())
-				bs.SaveUnscanRecord(unscanRecord)
-				bs.wm.Log.Std.Info("block height: %d extract failed.", height)
-				failed++ //标记保存失败数
+	//提取工作，每个线程只写入自己负责的结果
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				results[index] = bs.ExtractTransaction(blockHeight, blockHash, txs[index], bs.ScanAddressFunc, memPool)
+				results[index].fork = fork
 			}
-			//累计完成的线程数
-			done++
-			if done == shouldDone {
-				//bs.wm.Log.Std.Info("done = %d, shouldDone = %d ", done, len(txs))
-				close(quit) //关闭通道，等于给通道传入nil
-			}
-		}
+		}()
 	}
 
-	//提取工作
-	extractWork := func(eblockHeight uint64, eBlockHash string, mTxs []string, eProducer chan ExtractResult) {
-		for _, txid := range mTxs {
-			bs.extractingCH <- struct{}{}
-			//shouldDone++
-			go func(mBlockHeight uint64, mTxid string, end chan struct{}, mProducer chan<- ExtractResult) {
+	for index := range txs {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
 
-				//导出提出的交易
-				mProducer <- bs.ExtractTransaction(mBlockHeight, eBlockHash, mTxid, bs.ScanAddressFunc, memPool)
-				//释放
-				<-end
+	extractErr := &ExtractError{BlockHeight: blockHeight}
 
-			}(eblockHeight, txid, bs.extractingCH, eProducer)
+	for i := range results {
+		gets := &results[i]
+
+		if gets.Success && len(gets.extractData) > 0 {
+			extracted = append(extracted, gets.TxID)
 		}
-	}
 
-	/*	开启导出的线程	*/
+		if !bs.notifyExtractResult(blockHeight, gets) {
+			extractErr.add(gets.TxID, gets.Reason)
+		}
+	}
 
-	//独立线程运行消费
-	go saveWork(blockHeight, worker)
+	//记录区块已提取的交易单，分叉时通知失效
+	if !memPool {
+		bs.saveExtractedTxIDs(fork, blockHeight, blockHash, extracted)
+	}
 
-	//独立线程运行生产
-	go extractWork(blockHeight, blockHash, txs, producer)
+	if len(extractErr.Failures) > 0 {
+		return extractErr
+	}
+	return nil
+}
 
-	//以下使用生产消费模式
-	bs.extractRuntime(producer, worker, quit)
+//notifyExtractResult 通知提取结果，提取失败记录未扫区块，返回是否成功
+func (bs *BBCBlockScanner) notifyExtractResult(height uint64, gets *ExtractResult) bool {
 
-	if failed > 0 {
-		return fmt.Errorf("block scanner saveWork failed")
-	} else {
-		return nil
+	if !gets.Success {
+		//交易池的交易下次扫描交易池时会重新提取，不记录
+		if gets.memPool {
+			return false
+		}
+		//按交易单记录未扫记录
+		err := bs.saveUnscanRecord(gets.fork, height, gets.blockHash, gets.TxID, gets.Reason)
+		if err != nil {
+			bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err)
+		}
+		bs.wm.Log.Std.Info("block height: %d tx: %s extract failed.", height, gets.TxID)
+		return false
 	}
 
-	//return nil
-}
+	//记录地址交易索引，交易池中未确认的交易不记录
+	if !gets.memPool {
+		err := bs.indexExtractData(gets.extractData)
+		if err != nil {
+			bs.wm.Log.Std.Error("block scanner can not index tx: %s; unexpected error: %v", gets.TxID, err)
+		}
+	}
 
-//extractRuntime 提取运行时
-func (bs *BBCBlockScanner) extractRuntime(producer chan ExtractResult, worker chan ExtractResult, quit chan struct{}) {
+	//未达到确认数的提取结果暂存，达到后再通知
+	if !gets.memPool && len(gets.extractData) > 0 {
+		held, err := bs.confirmGate(height, gets)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not hold extract data; unexpected error: %v", err)
+			gets.Reason = err.Error()
+			return false
+		}
+		if held {
+			if bs.IsScanMemPool {
+				bs.wm.deleteMemPoolTx(gets.TxID)
+			}
+			return true
+		}
+	}
 
-	var (
-		values = make([]ExtractResult, 0)
-	)
+	notifyErr := bs.newExtractDataNotify(gets.fork, height, gets.extractData)
+	//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
+	if notifyErr != nil {
+		bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+		gets.Reason = notifyErr.Error()
+		return false
+	}
 
-	for {
-		var activeWorker chan<- ExtractResult
-		var activeValue ExtractResult
-		//当数据队列有数据时，释放顶部，传输给消费者
-		if len(values) > 0 {
-			activeWorker = worker
-			activeValue = values[0]
+	if gets.memPool {
+		record := gets.pool
+		if record == nil {
+			record = &MemPoolTx{TxID: gets.TxID}
 		}
-		select {
-		//生成者不断生成数据，插入到数据队列尾部
-		case pa := <-producer:
-			values = append(values, pa)
-		case <-quit:
-			//退出
-			//bs.wm.Log.Std.Info("block scanner have been scanned!")
+		record.Notified = len(gets.extractData) > 0
+		bs.wm.saveMemPoolTx(record)
+	} else if bs.IsScanMemPool {
+		bs.wm.deleteMemPoolTx(gets.TxID)
+	}
+	return true
+}
+
+//saveExtractedTxIDs 记录区块已提取的交易单，分叉时通知失效
+func (bs *BBCBlockScanner) saveExtractedTxIDs(fork string, height uint64, hash string, txids []string) {
+	if len(hash) == 0 || len(txids) == 0 {
+		return
+	}
+	err := bs.saveBlockTxIDs(fork, height, hash, txids)
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not save extracted txs of block: %s; unexpected error: %v", hash, err)
+	}
+}
+
+//addExtractedTxID 重扫成功的交易单追加到区块已提取的交易单记录
+func (bs *BBCBlockScanner) addExtractedTxID(fork string, height uint64, hash string, txid string) {
+	if len(hash) == 0 {
+		return
+	}
+	txids, err := bs.getBlockTxIDs(hash)
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not get extracted txs of block: %s; unexpected error: %v", hash, err)
+		return
+	}
+	for _, id := range txids {
+		if id == txid {
 			return
-		case activeWorker <- activeValue:
-			values = values[1:]
 		}
 	}
-	//return
-
+	bs.saveExtractedTxIDs(fork, height, hash, append(txids, txid))
 }
 
 //ExtractTransaction 提取交易单
@@ -484,6 +515,8 @@ func (bs *BBCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 			TxID:        txid,
 			extractData: make(map[string]*openwallet.TxExtractData),
 			Success:     true,
+			memPool:     memPool,
+			blockHash:   blockHash,
 		}
 	)
 
@@ -497,18 +530,19 @@ func (bs *BBCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 			if err != nil {
 				bs.wm.Log.Std.Info("block scanner can not extract transaction data in mempool and block chain; unexpected error: %v", err)
 				result.Success = false
+				result.Reason = err.Error()
 				return result
 			}
 		}
 	} else {
 		trx, err = bs.wm.GetTransaction(txid)
-		trx.BlockHash = blockHash
-
 		if err != nil {
 			bs.wm.Log.Std.Info("block scanner can not extract transaction data; unexpected error: %v", err)
 			result.Success = false
+			result.Reason = err.Error()
 			return result
 		}
+		trx.BlockHash = blockHash
 	}
 
 	//优先使用传入的高度
@@ -564,83 +598,165 @@ func (bs *BBCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 		success = true
 	)
 	createAt := time.Now().Unix()
-	anchor, err := bs.wm.Client.getAnchor()
+	if trx == nil {
+		result.Success = success
+		return
+	}
+	//证书交易不涉及资产转移，不提取
+	if trx.Type == TxTypeNameCert {
+		result.Success = success
+		return
+	}
+	//主链交易为主币，已配置的子链交易为子链资产
+	coin, isSupport, err := bs.getCoinByAnchor(trx.Anchor)
 	if err != nil {
-		success = false
+		result.Success = false
+		result.Reason = err.Error()
 		return
 	}
-	if trx == nil || trx.Anchor != anchor {
+	if !isSupport {
 		//记录哪个区块哪个交易单没有完成扫描
 		success = true
 	} else {
-		sourceKey, ok := scanAddressFunc(trx.From)
-		if ok {
-			input := openwallet.TxInput{}
-			input.TxID = trx.TxID
-			input.Address = trx.From
-			input.Amount = convertToAmount(trx.Amount)
-			input.Coin = openwallet.Coin{
-				Symbol:     bs.wm.Symbol(),
-				IsContract: false,
+		memo, memoType, memoHex := decodeMemoHex(trx.Memo)
+
+		//交易池中未确认的交易标记为pending，上链后再次通知并标记曾以pending通知过
+		pending := result.memPool && trx.Confirmations == 0
+		wasPending := false
+		if !result.memPool && bs.IsScanMemPool {
+			if record, err := bs.wm.getMemPoolTx(trx.TxID); err == nil {
+				wasPending = record.Notified
 			}
-			input.Index = 0
-			input.Sid = openwallet.GenTxInputSID(trx.TxID, bs.wm.Symbol(), "", uint64(0))
-			input.CreateAt = createAt
-			input.BlockHash = trx.BlockHash
-			input.BlockHeight = trx.BlockHeight
+		}
+
+		extractData := func(sourceKey string) *openwallet.TxExtractData {
 			ed := result.extractData[sourceKey]
 			if ed == nil {
 				ed = openwallet.NewBlockExtractData()
 				result.extractData[sourceKey] = ed
 			}
+			return ed
+		}
 
-			ed.TxInputs = append(ed.TxInputs, &input)
+		//奖励交易没有发送方，只记录输出
+		fromKey, fromWatched := "", false
+		if !trx.IsReward() {
+			fromKey, fromWatched = scanAddressFunc(trx.From)
+		}
 
-			tmp := *&input
-			feeCharge := &tmp
+		//发送方是已监听地址时解析输入引用的输出，计算找零
+		var (
+			inputs      []*OutPoint
+			inputAmount = trx.Amount + trx.Fee
+		)
+		if fromWatched {
+			inputs, inputAmount, err = bs.getTxInputs(trx, !result.memPool && !result.readOnly)
+			if err != nil {
+				result.Success = false
+				result.Reason = err.Error()
+				return
+			}
 
-			feeCharge.Amount = convertToAmount(trx.Fee)
-			feeCharge.Index = 1
-			feeCharge.Sid = openwallet.GenTxInputSID(trx.TxID, bs.wm.Symbol(), "", uint64(1))
-			ed.TxInputs = append(ed.TxInputs, feeCharge)
+			ed := extractData(fromKey)
+
+			//节点没有返回vin时只记录一个输入
+			if len(inputs) == 0 {
+				inputs = []*OutPoint{&OutPoint{Address: trx.From, Amount: inputAmount}}
+			}
+
+			for i, point := range inputs {
+				input := openwallet.TxInput{}
+				input.SourceTxID = point.TxID
+				input.SourceIndex = uint64(point.Vout)
+				input.TxID = trx.TxID
+				input.Address = trx.From
+				input.Amount = convertToAmount(point.Amount)
+				input.Coin = coin
+				input.Index = uint64(i)
+				input.Sid = openwallet.GenTxInputSID(trx.TxID, bs.wm.Symbol(), coin.ContractID, uint64(i))
+				input.CreateAt = createAt
+				input.BlockHash = trx.BlockHash
+				input.BlockHeight = trx.BlockHeight
+				ed.TxInputs = append(ed.TxInputs, &input)
+			}
 		}
 
-		sourceKey, ok = scanAddressFunc(trx.To)
-		if ok {
+		//vout0为接收方，vout1为找零回发送方
+		vouts := txVouts(trx, inputAmount)
+		owned := make([]*OutPoint, 0, len(vouts))
+		for _, vout := range vouts {
+			sourceKey, ok := fromKey, fromWatched
+			if vout.Vout == VoutTo {
+				sourceKey, ok = bs.getDepositSourceKey(trx.To, memo, scanAddressFunc)
+			}
+			if !ok {
+				continue
+			}
+			owned = append(owned, vout)
+
 			output := openwallet.TxOutPut{}
 			output.TxID = trx.TxID
-			output.Address = trx.To
-			output.Amount = convertToAmount(trx.Amount)
-			output.Coin = openwallet.Coin{
-				Symbol:    bs.wm.Symbol(),
-				IsContract: false,
-			}
-			output.Index = 0
-			output.Sid = openwallet.GenTxOutPutSID(trx.TxID, bs.wm.Symbol(), "", 0)
+			output.Address = vout.Address
+			output.Amount = convertToAmount(vout.Amount)
+			output.Coin = coin
+			output.Index = uint64(vout.Vout)
+			output.Sid = openwallet.GenTxOutPutSID(trx.TxID, bs.wm.Symbol(), coin.ContractID, uint64(vout.Vout))
 			output.CreateAt = createAt
 			output.BlockHeight = trx.BlockHeight
 			output.BlockHash = trx.BlockHash
 
-			ed := result.extractData[sourceKey]
-			if ed == nil {
-				ed = openwallet.NewBlockExtractData()
-				result.extractData[sourceKey] = ed
+			ed := extractData(sourceKey)
+			ed.TxOutputs = append(ed.TxOutputs, &output)
+		}
+
+		//记录已监听地址相关交易的输出和UTXO，交易池中未确认的交易不写入，上链后再记录
+		if !result.memPool && !result.readOnly {
+			if len(result.extractData) > 0 {
+				bs.saveOutPoints(vouts)
 			}
 
-			ed.TxOutputs = append(ed.TxOutputs, &output)
+			err = bs.updateUTXOs(trx, inputs, owned)
+			if err != nil {
+				result.Success = false
+				result.Reason = err.Error()
+				return
+			}
+		}
+
+		//交易池中的交易记录到交易池记录，本地UTXO集合据此统计未确认余额
+		if result.memPool {
+			result.pool = newMemPoolTx(trx, inputs, owned)
+		}
+
+		//交易改变了发送方和接收方的余额
+		if !result.readOnly {
+			bs.wm.balanceCache.invalidate(trx.From, trx.To)
+		}
+
+		//DPoS相关交易：出块奖励、投票、撤回投票
+		dposAction := ""
+		if len(result.extractData) > 0 {
+			dposAction = bs.wm.getDPoSAction(trx)
+		}
+
+		from := make([]string, 0)
+		if !trx.IsReward() {
+			from = append(from, trx.From+":"+convertToAmount(inputAmount))
+		}
+
+		to := make([]string, 0, len(vouts))
+		for _, vout := range vouts {
+			to = append(to, vout.Address+":"+convertToAmount(vout.Amount))
 		}
 
 		for _, extractData := range result.extractData {
 
 			tx := &openwallet.Transaction{
-				From:[]string{trx.From + ":" + convertToAmount(trx.Amount)},
-				To:[]string{trx.To + ":" + convertToAmount(trx.Amount)},
+				From:from,
+				To:to,
 				Amount:convertToAmount(trx.Amount),
 				Fees:convertToAmount(trx.Fee),
-				Coin: openwallet.Coin{
-					Symbol:     bs.wm.Symbol(),
-					IsContract: false,
-				},
+				Coin: coin,
 				BlockHash:   trx.BlockHash,
 				BlockHeight: trx.BlockHeight,
 				TxID:        trx.TxID,
@@ -650,7 +766,25 @@ func (bs *BBCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 				ConfirmTime: int64(trx.TimeStamp),
 			}
 
-			tx.SetExtParam("memo", trx.Memo)
+			tx.SetExtParam("memo", memo)
+			tx.SetExtParam("memoType", memoType)
+			tx.SetExtParam("memoHex", memoHex)
+			tx.SetExtParam("txType", trx.Type)
+			tx.SetExtParam("pending", pending)
+			if pending {
+				tx.ConfirmTime = 0
+			}
+			if wasPending {
+				tx.SetExtParam("wasPending", true)
+			}
+			//转给自己，vout0和找零都回到发送方
+			if !trx.IsReward() && trx.From == trx.To {
+				tx.SetExtParam("selfTransfer", true)
+			}
+			if len(dposAction) > 0 {
+				tx.TxAction = dposAction
+				tx.SetExtParam("dpos", dposAction)
+			}
 			wxID := openwallet.GenTransactionWxID(tx)
 			tx.WxID = wxID
 			extractData.Transaction = tx
@@ -662,17 +796,56 @@ func (bs *BBCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 	result.Success = success
 }
 
-//newExtractDataNotify 发送通知
-func (bs *BBCBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
+//getCoinByAnchor 根据交易的anchor获取资产，主链为主币，子链为子链资产
+func (bs *BBCBlockScanner) getCoinByAnchor(anchor string) (openwallet.Coin, bool, error) {
+
+	mainAnchor, err := bs.wm.getAnchor()
+	if err != nil {
+		return openwallet.Coin{}, false, err
+	}
+
+	if anchor == mainAnchor {
+		return openwallet.Coin{
+			Symbol:     bs.wm.Symbol(),
+			IsContract: false,
+		}, true, nil
+	}
+
+	contract, ok := bs.wm.Config.SubForks[anchor]
+	if !ok {
+		return openwallet.Coin{}, false, nil
+	}
+
+	return openwallet.Coin{
+		Symbol:     bs.wm.Symbol(),
+		IsContract: true,
+		ContractID: contract.ContractID,
+		Contract:   *contract,
+	}, true, nil
+}
+
+//newExtractDataNotify 发送通知，通知失败的交易单记录未扫记录，返回最后一个通知错误
+func (bs *BBCBlockScanner) newExtractDataNotify(fork string, height uint64, extractData map[string]*openwallet.TxExtractData) error {
+
+	var notifyErr error
 
 	for o, _ := range bs.Observers {
 		for key, data := range extractData {
 			err := o.BlockExtractDataNotify(key, data)
 			if err != nil {
 				bs.wm.Log.Error("BlockExtractDataNotify unexpected error:", err)
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.",bs.wm.Symbol())
-				err = bs.SaveUnscanRecord(unscanRecord)
+				notifyErr = err
+				//交易池的交易下次扫描交易池时重新通知，不记录
+				if height == 0 {
+					continue
+				}
+				//记录未扫记录
+				txid, blockHash := "", ""
+				if data.Transaction != nil {
+					txid = data.Transaction.TxID
+					blockHash = data.Transaction.BlockHash
+				}
+				err = bs.saveUnscanRecord(fork, height, blockHash, txid, "ExtractData Notify failed: "+err.Error())
 				if err != nil {
 					bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
 				}
@@ -681,27 +854,30 @@ func (bs *BBCBlockScanner) newExtractDataNotify(height uint64, extractData map[s
 		}
 	}
 
-	return nil
+	return notifyErr
 }
 
-//DeleteUnscanRecordNotFindTX 删除未没有找到交易记录的重扫记录
+//DeleteUnscanRecordNotFindTX 找不到交易记录的未扫记录重扫不会成功，转入死信等待人工处理
 func (bs *BBCBlockScanner) DeleteUnscanRecordNotFindTX() error {
 
-	//删除找不到交易单
+	//找不到交易单
 	reason := "[-5]No information available about transaction"
 
 	if bs.BlockchainDAI == nil {
 		return fmt.Errorf("Blockchain DAI is not setup ")
 	}
 
-	list, err := bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
+	list, err := bs.getAllUnscanRecords()
 	if err != nil {
 		return err
 	}
 
 	for _, r := range list {
 		if strings.HasPrefix(r.Reason, reason) {
-			bs.BlockchainDAI.DeleteUnscanRecordByID(r.ID, bs.wm.Symbol())
+			err = bs.moveUnscanRecordToDead(r)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -820,9 +996,22 @@ func (bs *BBCBlockScanner) ExtractTransactionData(txid string, scanTargetFunc op
 		}
 		return scanTargetFunc(target)
 	}
-	result := bs.ExtractTransaction(0, "", txid, scanAddressFunc, false)
+	trx, err := bs.wm.GetTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	//只查询交易数据，不写入本地输出、UTXO和余额缓存
+	result := ExtractResult{
+		BlockHeight: trx.BlockHeight,
+		TxID:        txid,
+		extractData: make(map[string]*openwallet.TxExtractData),
+		Success:     true,
+		readOnly:    true,
+	}
+	bs.extractTransaction(trx, &result, scanAddressFunc)
 	if !result.Success {
-		return nil, fmt.Errorf("extract transaction failed")
+		return nil, fmt.Errorf("extract transaction failed: %s", result.Reason)
 	}
 	extData := make(map[string][]*openwallet.TxExtractData)
 	for key, data := range result.extractData {
@@ -892,39 +1081,75 @@ func (wm *WalletManager) GetBlock(hash string) (*Block, error) {
 	return nil, openwallet.Errorf(openwallet.ErrUnknownException, "Get block by block hash is not supported!")
 }
 
-//GetTxIDsInMemPool 获取待处理的交易池中的交易单IDs
-func (wm *WalletManager) GetTxIDsInMemPool() ([]string, error) {
-	return nil, nil
+//GetTransaction 获取交易单
+func (wm *WalletManager) GetTransaction(txid string) (*Transaction, error) {
+	return wm.Client.getTransaction(txid)
 }
 
-func (wm *WalletManager) GetTransactionInMemPool(txid string) (*Transaction, error) {
-	return nil, nil
+//AddressBalance 地址余额明细，openwallet.Balance没有扩展字段，锁定余额和投票余额单独返回
+type AddressBalance struct {
+	*openwallet.Balance
+	LockedBalance string //锁定余额，已计入Balance，不可花费
+	VotedBalance  string //投票锁定在投票模板地址的余额，不计入Balance，撤回后才可花费
 }
 
-//GetTransaction 获取交易单
-func (wm *WalletManager) GetTransaction(txid string) (*Transaction, error) {
-	return wm.Client.getTransaction(txid)
+//newAddressBalance 转换地址余额，Balance为总余额，ConfirmBalance为可花费余额，UnconfirmBalance为未确认余额
+func newAddressBalance(symbol string, balance *AddrBalance) *AddressBalance {
+	total := new(big.Int).Add(balance.Balance, balance.Locked)
+	total.Add(total, balance.Unconfirmed)
+
+	return &AddressBalance{
+		Balance: &openwallet.Balance{
+			Symbol:           symbol,
+			Address:          balance.Address,
+			Balance:          convertToAmount(total.Uint64()),
+			ConfirmBalance:   convertToAmount(balance.Balance.Uint64()),
+			UnconfirmBalance: convertToAmount(balance.Unconfirmed.Uint64()),
+		},
+		LockedBalance: convertToAmount(balance.Locked.Uint64()),
+		VotedBalance:  "0",
+	}
 }
 
-//GetAssetsAccountBalanceByAddress 查询账户相关地址的交易记录
-func (bs *BBCBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet.Balance, error) {
+//GetBalanceDetailByAddress 查询地址的总余额、可花费余额、未确认余额、锁定余额和投票余额
+func (bs *BBCBlockScanner) GetBalanceDetailByAddress(address ...string) ([]*AddressBalance, error) {
 
-	addrsBalance := make([]*openwallet.Balance, 0)
-	anchor, err := bs.wm.Client.getAnchor()
+	addrsBalance := make([]*AddressBalance, 0)
+	anchor, err := bs.wm.getAnchor()
 	if err != nil {
 		return nil, openwallet.Errorf(openwallet.ErrUnknownException, "Fail to get anchor!")
 	}
-	for _, addr := range address {
-		balance, err := bs.wm.Client.getBalance(addr, anchor)
-		if err != nil {
-			return nil, err
-		}
 
-		addrsBalance = append(addrsBalance, &openwallet.Balance{
-			Symbol:  bs.wm.Symbol(),
-			Address: addr,
-			Balance: convertToAmount(uint64(balance.Balance.Int64())),
-		})
+	balances, err := bs.wm.getAddressBalances(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	voted, err := bs.wm.getVotedBalances(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, balance := range balances {
+		detail := newAddressBalance(bs.wm.Symbol(), balance)
+		detail.VotedBalance = convertToAmount(voted[balance.Address])
+		addrsBalance = append(addrsBalance, detail)
+	}
+
+	return addrsBalance, nil
+}
+
+//GetAssetsAccountBalanceByAddress 查询账户相关地址的余额，可花费的部分为ConfirmBalance
+func (bs *BBCBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet.Balance, error) {
+
+	list, err := bs.GetBalanceDetailByAddress(address...)
+	if err != nil {
+		return nil, err
+	}
+
+	addrsBalance := make([]*openwallet.Balance, 0, len(list))
+	for _, balance := range list {
+		addrsBalance = append(addrsBalance, balance.Balance)
 	}
 
 	return addrsBalance, nil
@@ -955,8 +1180,8 @@ func (c *Client) getMultiAddrTransactions(offset, limit int, addresses ...string
 	return nil, nil
 }
 
-//GetAssetsAccountTransactionsByAddress 查询账户相关地址的交易记录
-func (bs *BBCBlockScanner) GetTransactionsByAddress(offset, limit int, coin openwallet.Coin, address ...string) ([]*openwallet.TxExtractData, error) {
+//getTransactionsByAddressFromNode 通过节点接口查询账户相关地址的交易记录
+func (bs *BBCBlockScanner) getTransactionsByAddressFromNode(offset, limit int, coin openwallet.Coin, address ...string) ([]*openwallet.TxExtractData, error) {
 
 	var (
 		array = make([]*openwallet.TxExtractData, 0)
@@ -988,6 +1213,7 @@ func (bs *BBCBlockScanner) GetTransactionsByAddress(offset, limit int, coin open
 			TxID:        tx.TxID,
 			extractData: make(map[string]*openwallet.TxExtractData),
 			Success:     true,
+			readOnly:    true,
 		}
 
 		bs.extractTransaction(tx, &result, scanAddressFunc)
@@ -1005,118 +1231,46 @@ func (bs *BBCBlockScanner) GetTransactionsByAddress(offset, limit int, coin open
 //Run 运行
 func (bs *BBCBlockScanner) Run() error {
 
-	bs.BlockScannerBase.Run()
+	err := bs.BlockScannerBase.Run()
+	if err != nil {
+		return err
+	}
 
-	return nil
+	bs.startBlockListener()
+
+	return bs.startMetricsServer()
 }
 
 ////Stop 停止扫描
 func (bs *BBCBlockScanner) Stop() error {
 
-	bs.BlockScannerBase.Stop()
+	err := bs.BlockScannerBase.Stop()
 
-	return nil
+	bs.stopBlockListener()
+	bs.stopMetricsServer()
+
+	return err
 }
 
 //Pause 暂停扫描
 func (bs *BBCBlockScanner) Pause() error {
 
-	bs.BlockScannerBase.Pause()
+	err := bs.BlockScannerBase.Pause()
 
-	return nil
+	bs.stopBlockListener()
+
+	return err
 }
 
 //Restart 继续扫描
 func (bs *BBCBlockScanner) Restart() error {
 
-	bs.BlockScannerBase.Restart()
-
-	return nil
-}
-
-/******************* 使用insight socket.io 监听区块 *******************/
-
-//setupSocketIO 配置socketIO监听新区块
-func (bs *BBCBlockScanner) setupSocketIO() error {
-
-	bs.wm.Log.Info("block scanner use socketIO to listen new data")
-
-	var (
-		room = "inv"
-	)
-
-	if bs.socketIO == nil {
-
-		apiUrl, err := url.Parse(bs.wm.Config.ServerAPI)
-		if err != nil {
-			return err
-		}
-		domain := apiUrl.Hostname()
-		port := common.NewString(apiUrl.Port()).Int()
-		c, err := gosocketio.Dial(
-			gosocketio.GetUrl(domain, port, false),
-			transport.GetDefaultWebsocketTransport())
-		if err != nil {
-			return err
-		}
-
-		bs.socketIO = c
-
-	}
-
-	err := bs.socketIO.On("tx", func(h *gosocketio.Channel, args interface{}) {
-		//bs.wm.Log.Info("block scanner socketIO get new transaction received: ", args)
-		txMap, ok := args.(map[string]interface{})
-		if ok {
-			txid := txMap["txid"].(string)
-			errInner := bs.BatchExtractTransaction(0, "", []string{txid}, false)
-			if errInner != nil {
-				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", errInner)
-			}
-		}
-
-	})
+	err := bs.BlockScannerBase.Restart()
 	if err != nil {
 		return err
 	}
 
-	/*
-		err = bs.socketIO.On("block", func(h *gosocketio.Channel, args interface{}) {
-			bs.wm.Log.Info("block scanner socketIO get new block received: ", args)
-			hash, ok := args.(string)
-			if ok {
-
-				block, errInner := bs.wm.GetBlock(hash)
-				if errInner != nil {
-					bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", errInner)
-				}
-
-				errInner = bs.scanBlock(block)
-				if errInner != nil {
-					bs.wm.Log.Std.Info("block scanner can not block: %d; unexpected error: %v", block.Height, errInner)
-				}
-			}
-
-		})
-		if err != nil {
-			return err
-		}
-	*/
-
-	err = bs.socketIO.On(gosocketio.OnDisconnection, func(h *gosocketio.Channel) {
-		bs.wm.Log.Info("block scanner socketIO disconnected")
-	})
-	if err != nil {
-		return err
-	}
-
-	err = bs.socketIO.On(gosocketio.OnConnection, func(h *gosocketio.Channel) {
-		bs.wm.Log.Info("block scanner socketIO connected")
-		h.Emit("subscribe", room)
-	})
-	if err != nil {
-		return err
-	}
+	bs.startBlockListener()
 
 	return nil
 }
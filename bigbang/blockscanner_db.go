@@ -54,6 +54,11 @@ func (bs *BBCBlockScanner) GetLocalBlockHead() (uint32, string, error) {
 
 //SaveLocalBlock 记录本地新区块
 func (bs *BBCBlockScanner) SaveLocalBlock(blockHeader *Block) error {
+	return bs.saveForkLocalBlock("", blockHeader)
+}
+
+//saveForkLocalBlock 记录分支的本地新区块，fork为空则为主链
+func (bs *BBCBlockScanner) saveForkLocalBlock(fork string, blockHeader *Block) error {
 
 	if bs.BlockchainDAI == nil {
 		return fmt.Errorf("Blockchain DAI is not setup ")
@@ -65,7 +70,7 @@ func (bs *BBCBlockScanner) SaveLocalBlock(blockHeader *Block) error {
 		Previousblockhash: blockHeader.PrevBlockHash,
 		Height:            blockHeader.Height,
 		Time:              blockHeader.Timestamp,
-		Symbol:            bs.wm.Symbol(),
+		Symbol:            bs.forkSymbol(fork),
 	}
 
 	return bs.BlockchainDAI.SaveLocalBlockHead(header)
@@ -73,19 +78,28 @@ func (bs *BBCBlockScanner) SaveLocalBlock(blockHeader *Block) error {
 
 //GetLocalBlock 获取本地区块数据
 func (bs *BBCBlockScanner) GetLocalBlock(height uint32) (*Block, error) {
+	return bs.getForkLocalBlock("", uint64(height))
+}
+
+//getForkLocalBlock 获取分支的本地区块数据，fork为空则为主链
+func (bs *BBCBlockScanner) getForkLocalBlock(fork string, height uint64) (*Block, error) {
 
 	if bs.BlockchainDAI == nil {
 		return nil, fmt.Errorf("Blockchain DAI is not setup ")
 	}
 
-	header, err := bs.BlockchainDAI.GetLocalBlockHeadByHeight(uint64(height), bs.wm.Symbol())
+	header, err := bs.BlockchainDAI.GetLocalBlockHeadByHeight(height, bs.forkSymbol(fork))
 	if err != nil {
 		return nil, err
 	}
 
 	block := &Block{
-		Hash: header.Hash,
-		Height:  header.Height,
+		Hash:                  header.Hash,
+		PrevBlockHash:         header.Previousblockhash,
+		Fork:                  fork,
+		TransactionMerkleRoot: header.Merkleroot,
+		Timestamp:             header.Time,
+		Height:                header.Height,
 	}
 
 	return block, nil
@@ -103,12 +117,17 @@ func (bs *BBCBlockScanner) SaveUnscanRecord(record *openwallet.UnscanRecord) err
 
 //DeleteUnscanRecord 删除指定高度的未扫记录
 func (bs *BBCBlockScanner) DeleteUnscanRecord(height uint32) error {
+	return bs.deleteForkUnscanRecord("", uint64(height))
+}
+
+//deleteForkUnscanRecord 删除分支指定高度的未扫记录，fork为空则为主链
+func (bs *BBCBlockScanner) deleteForkUnscanRecord(fork string, height uint64) error {
 
 	if bs.BlockchainDAI == nil {
 		return fmt.Errorf("Blockchain DAI is not setup ")
 	}
 
-	return bs.BlockchainDAI.DeleteUnscanRecordByHeight(uint64(height), bs.wm.Symbol())
+	return bs.BlockchainDAI.DeleteUnscanRecordByHeight(height, bs.forkSymbol(fork))
 }
 
 func (bs *BBCBlockScanner) GetUnscanRecords() ([]*openwallet.UnscanRecord, error) {
@@ -120,6 +139,24 @@ func (bs *BBCBlockScanner) GetUnscanRecords() ([]*openwallet.UnscanRecord, error
 	return bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
 }
 
+//getAllUnscanRecords 获取主链和已配置子链的未扫记录
+func (bs *BBCBlockScanner) getAllUnscanRecords() ([]*openwallet.UnscanRecord, error) {
+
+	list, err := bs.GetUnscanRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	for fork := range bs.wm.Config.SubForks {
+		records, err := bs.BlockchainDAI.GetUnscanRecords(bs.forkSymbol(fork))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, records...)
+	}
+	return list, nil
+}
+
 //SupportBlockchainDAI 支持外部设置区块链数据访问接口
 //@optional
 func (bs *BBCBlockScanner) SupportBlockchainDAI() bool {
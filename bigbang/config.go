@@ -23,6 +23,7 @@ import (
 
 	owcrypt "github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/common/file"
+	"github.com/blocktree/openwallet/openwallet"
 	"github.com/shopspring/decimal"
 )
 
@@ -89,10 +90,50 @@ type WalletConfig struct {
 	CoinDecimal decimal.Decimal
 	//核心钱包密码，配置有值用于自动解锁钱包
 	WalletPassword string
-	// fixed fee
+	// fixed fee，大于0时覆盖手续费估算
 	FixedFee uint64
+	//最低手续费
+	MinFee uint64
+	//是否向节点查询手续费
+	FeeFromNode bool
 	//数据目录
 	DataDir string
+	//交易未确认时最大重播次数
+	MaxRebroadcast int
+	//最大分叉回退深度
+	MaxReorgDepth uint64
+	//未扫记录最大重扫次数，超过后转入死信
+	MaxRescanAttempts int
+	//未扫记录首次重扫间隔，之后按指数增长
+	RescanBackoff time.Duration
+	//追块时预取区块的窗口大小，小于等于1则逐个获取
+	PrefetchWindow int
+	//充值通知需要的确认数，小于等于1则上链即通知
+	ConfirmThreshold uint64
+	//确认数未达到时的通知方式：hold或twice
+	ConfirmMode string
+	//主链anchor，即创世区块hash，为空则向节点查询
+	Anchor string
+	//子链资产，key为子链分支hash
+	SubForks map[string]*openwallet.SmartContract
+	//新区块推送的websocket地址，为空则只定时轮询
+	BlockListenURL string
+	//连接推送后发送的订阅消息
+	BlockListenSubscribe string
+	//扫描器Prometheus指标服务的监听地址，为空则不启动
+	MetricsListen string
+	//是否记录已监听地址的交易索引，用于查询地址交易记录
+	AddressIndex bool
+	//是否由扫描器维护已监听地址的本地UTXO集合，余额和UTXO选择不再依赖节点钱包
+	LocalUTXO bool
+	//地址余额缓存时间，0则不缓存
+	BalanceCacheTTL time.Duration
+	//查询多个地址余额的并发数
+	BalanceConcurrency int
+	//共享充值地址，充值按memo归属账户
+	SharedAddresses map[string]bool
+	//共享地址memo无法识别时归入的暂存账户源标识
+	SuspenseSourceKey string
 }
 
 func NewConfig(symbol string, masterKey string) *WalletConfig {
@@ -150,6 +191,28 @@ func NewConfig(symbol string, masterKey string) *WalletConfig {
 	c.CoinDecimal = decimal.NewFromFloat(100000000)
 	//核心钱包密码，配置有值用于自动解锁钱包
 	c.WalletPassword = ""
+	//最低手续费
+	c.MinFee = 10000
+	//交易未确认时最大重播次数
+	c.MaxRebroadcast = 10
+	//最大分叉回退深度
+	c.MaxReorgDepth = 100
+	//未扫记录最大重扫次数
+	c.MaxRescanAttempts = 10
+	//未扫记录首次重扫间隔
+	c.RescanBackoff = time.Minute
+	//预取区块窗口
+	c.PrefetchWindow = 1
+	//地址余额缓存时间
+	c.BalanceCacheTTL = 10 * time.Second
+	//查询地址余额的并发数
+	c.BalanceConcurrency = 10
+	//确认数未达到时暂存通知
+	c.ConfirmMode = ConfirmModeHold
+	//子链资产
+	c.SubForks = make(map[string]*openwallet.SmartContract)
+	//共享充值地址
+	c.SharedAddresses = make(map[string]bool)
 
 	//默认配置内容
 	c.DefaultConfig = `
@@ -250,3 +313,34 @@ func (wc *WalletConfig) makeDataDir() {
 	//创建目录
 	file.MkdirAll(wc.dbPath)
 }
+
+//parseSubForks 解析子链资产配置，格式：分支hash:代币符号,分支hash:代币符号
+func parseSubForks(value string) map[string]*openwallet.SmartContract {
+	subForks := make(map[string]*openwallet.SmartContract)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		pair := strings.SplitN(item, ":", 2)
+		fork := strings.TrimSpace(pair[0])
+		token := fork
+		if len(pair) == 2 {
+			token = strings.TrimSpace(pair[1])
+		}
+		subForks[fork] = NewSubForkContract(fork, token)
+	}
+	return subForks
+}
+
+//parseAddressSet 解析逗号分隔的地址列表
+func parseAddressSet(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			set[item] = true
+		}
+	}
+	return set
+}
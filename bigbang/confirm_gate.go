@@ -0,0 +1,192 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	heldExtractBucket = "held_extract"
+)
+
+//确认数未达到阈值时的通知方式
+const (
+	ConfirmModeHold  = "hold"  //暂存提取结果，达到确认数后才通知
+	ConfirmModeTwice = "twice" //先以pending通知，达到确认数后再以confirmed通知
+)
+
+//通知中的确认状态，保存在交易单ExtParam的confirmStatus
+const (
+	ConfirmStatusPending   = "pending"
+	ConfirmStatusConfirmed = "confirmed"
+)
+
+//HeldExtract 等待确认的提取结果
+type HeldExtract struct {
+	TxID        string `storm:"id"`
+	Fork        string `storm:"index"`
+	BlockHeight uint64
+	BlockHash   string `storm:"index"`
+	ExtractData map[string]*openwallet.TxExtractData
+}
+
+//setTipHeight 记录主链或子链的最新高度，用于计算确认数
+func (bs *BBCBlockScanner) setTipHeight(fork string, height uint64) {
+	bs.tipHeights.Store(fork, height)
+}
+
+//getConfirmations 计算区块的确认数，所在区块为1个确认
+func (bs *BBCBlockScanner) getConfirmations(fork string, height uint64) uint64 {
+	var tip uint64
+	if value, ok := bs.tipHeights.Load(fork); ok {
+		tip = value.(uint64)
+	}
+
+	if tip < height {
+		maxHeight, err := bs.wm.Client.getForkBlockHeight(fork)
+		if err != nil {
+			return 0
+		}
+		tip = maxHeight
+		bs.setTipHeight(fork, tip)
+	}
+
+	if tip < height {
+		return 0
+	}
+	return tip - height + 1
+}
+
+//setConfirmations 设置通知交易单的确认数和确认状态
+func setConfirmations(extractData map[string]*openwallet.TxExtractData, confirmations uint64, status string) {
+	for _, data := range extractData {
+		if data.Transaction == nil {
+			continue
+		}
+		data.Transaction.Confirm = int64(confirmations)
+		data.Transaction.SetExtParam("confirmations", confirmations)
+		if len(status) > 0 {
+			data.Transaction.SetExtParam("confirmStatus", status)
+		}
+	}
+}
+
+//confirmGate 按确认数阈值决定提取结果是否立即通知，返回true表示已暂存，暂不通知
+func (bs *BBCBlockScanner) confirmGate(height uint64, gets *ExtractResult) (bool, error) {
+
+	confirmations := bs.getConfirmations(gets.fork, height)
+	threshold := bs.wm.Config.ConfirmThreshold
+
+	if threshold <= 1 {
+		setConfirmations(gets.extractData, confirmations, "")
+		return false, nil
+	}
+
+	if confirmations >= threshold {
+		setConfirmations(gets.extractData, confirmations, ConfirmStatusConfirmed)
+		return false, nil
+	}
+
+	setConfirmations(gets.extractData, confirmations, ConfirmStatusPending)
+
+	blockHash := ""
+	for _, data := range gets.extractData {
+		if data.Transaction != nil {
+			blockHash = data.Transaction.BlockHash
+			break
+		}
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return false, err
+	}
+
+	err = db.From(heldExtractBucket).Save(&HeldExtract{
+		TxID:        gets.TxID,
+		Fork:        gets.fork,
+		BlockHeight: height,
+		BlockHash:   blockHash,
+		ExtractData: gets.extractData,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return bs.wm.Config.ConfirmMode != ConfirmModeTwice, nil
+}
+
+//releaseConfirmedExtracts 通知已达到确认数的暂存提取结果
+func (bs *BBCBlockScanner) releaseConfirmedExtracts() {
+
+	if bs.wm.Config.ConfirmThreshold <= 1 {
+		return
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not open db; unexpected error: %v", err)
+		return
+	}
+
+	var list []*HeldExtract
+	err = db.From(heldExtractBucket).All(&list)
+	if err != nil && err != storm.ErrNotFound {
+		bs.wm.Log.Std.Error("block scanner can not get held extract data; unexpected error: %v", err)
+		return
+	}
+
+	for _, held := range list {
+		confirmations := bs.getConfirmations(held.Fork, held.BlockHeight)
+		if confirmations < bs.wm.Config.ConfirmThreshold {
+			continue
+		}
+
+		setConfirmations(held.ExtractData, confirmations, ConfirmStatusConfirmed)
+		//通知失败的交易单已记录未扫记录，由重扫处理，不再暂存
+		err = bs.newExtractDataNotify(held.Fork, held.BlockHeight, held.ExtractData)
+		if err != nil {
+			bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", err)
+		}
+
+		err = db.From(heldExtractBucket).DeleteStruct(held)
+		if err != nil {
+			bs.wm.Log.Std.Error("block scanner can not delete held extract data: %s; unexpected error: %v", held.TxID, err)
+		}
+	}
+}
+
+//deleteHeldExtracts 删除孤块中暂存的提取结果
+func (bs *BBCBlockScanner) deleteHeldExtracts(blockHash string) error {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	var list []*HeldExtract
+	err = db.From(heldExtractBucket).Find("BlockHash", blockHash, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	for _, held := range list {
+		db.From(heldExtractBucket).DeleteStruct(held)
+	}
+	return nil
+}
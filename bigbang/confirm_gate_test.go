@@ -0,0 +1,83 @@
+package bigbang
+
+import (
+	"testing"
+)
+
+func TestConfirmGate(t *testing.T) {
+	cases := []struct {
+		mode    string
+		notices []string //依次通知的确认状态
+	}{
+		{ConfirmModeHold, []string{ConfirmStatusConfirmed}},
+		{ConfirmModeTwice, []string{ConfirmStatusPending, ConfirmStatusConfirmed}},
+	}
+
+	for _, c := range cases {
+		node := newMockNode()
+
+		node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+			return testTransaction(params["txid"].(string), "1sender", "1deposit", 1), nil
+		})
+
+		wm, observer := newTestScanner(t, node, "1deposit")
+		wm.Config.ConfirmThreshold = 3
+		wm.Config.ConfirmMode = c.mode
+		bs := wm.Blockscanner
+
+		bs.setTipHeight("", 10)
+		if err := bs.BatchExtractTransaction(10, "block_hash", []string{"txid_1"}, false); err != nil {
+			t.Fatalf("BatchExtractTransaction failed, unexpected error: %v", err)
+		}
+
+		bs.setTipHeight("", 11)
+		bs.releaseConfirmedExtracts()
+
+		bs.setTipHeight("", 12)
+		bs.releaseConfirmedExtracts()
+		bs.releaseConfirmedExtracts()
+
+		txs := observer.transactions()
+		if len(txs) != len(c.notices) {
+			t.Fatalf("mode: %s should notify %d times, got: %d", c.mode, len(c.notices), len(txs))
+		}
+		for i, tx := range txs {
+			if status := tx.GetExtParam().Get("confirmStatus").String(); status != c.notices[i] {
+				t.Errorf("mode: %s notice: %d status expected: %s, got: %s", c.mode, i, c.notices[i], status)
+			}
+		}
+		if last := txs[len(txs)-1]; last.Confirm != 3 || last.GetExtParam().Get("confirmations").Uint() != 3 {
+			t.Errorf("mode: %s confirmed notice should carry 3 confirmations, got: %d", c.mode, last.Confirm)
+		}
+
+		closeTestWalletManager(wm)
+		node.Close()
+	}
+}
+
+func TestConfirmGate_Orphan(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction(params["txid"].(string), "1sender", "1deposit", 1), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Config.ConfirmThreshold = 3
+	bs := wm.Blockscanner
+
+	bs.setTipHeight("", 10)
+	bs.BatchExtractTransaction(10, "block_hash", []string{"txid_1"}, false)
+
+	//区块成为孤块，暂存的提取结果作废
+	bs.deleteHeldExtracts("block_hash")
+
+	bs.setTipHeight("", 20)
+	bs.releaseConfirmedExtracts()
+
+	if n := len(observer.transactions()); n != 0 {
+		t.Errorf("held data of orphan block should not be notified, got: %d", n)
+	}
+}
@@ -12,7 +12,9 @@ import (
 
 type AddrBalance struct {
 	Address      string
-	Balance      *big.Int
+	Balance      *big.Int //可花费余额
+	Locked       *big.Int //锁定余额
+	Unconfirmed  *big.Int //未确认余额
 	index        int
 }
 
@@ -88,32 +90,74 @@ func convertToAmountWithDecimal(amount, decimals uint64) string {
 	return d.String()
 }
 
+//GetTokenBalanceByAddress 查询地址的子链资产余额，合约地址为子链分支hash
 func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
-	//var tokenBalanceList []*openwallet.TokenBalance
-	//
-	//for i := 0; i < len(address); i++ {
-	//	tokenBalance := openwallet.TokenBalance{
-	//		Contract: &contract,
-	//	}
-	//
-	//	balance, err := decoder.wm.Client.getContractAccountBalence(contract.Address, address[i])
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//
-	//	balanceUint, _ := strconv.ParseUint(balance.TokenBalance.String(), 10, 64)
-	//	tokenBalance.Balance = &openwallet.Balance{
-	//		Address:          address[i],
-	//		Symbol:           contract.Symbol,
-	//		Balance:          convertToAmountWithDecimal(balanceUint, contract.Decimals),
-	//		ConfirmBalance:   convertToAmountWithDecimal(balanceUint, contract.Decimals),
-	//		UnconfirmBalance: "0",
-	//	}
-	//
-	//	tokenBalanceList = append(tokenBalanceList, &tokenBalance)
-	//}
-	//
-	//return tokenBalanceList, nil
-
-	return nil, nil
-}
\ No newline at end of file
+
+	var tokenBalanceList []*openwallet.TokenBalance
+
+	if len(contract.Address) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractNotFound, "contract address is empty")
+	}
+
+	balances, err := decoder.wm.getAddressBalances(address, contract.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, balance := range balances {
+		tokenBalance := openwallet.TokenBalance{
+			Contract: &contract,
+		}
+
+		tokenBalance.Balance = newAddressBalance(contract.Symbol, balance).Balance
+
+		tokenBalanceList = append(tokenBalanceList, &tokenBalance)
+	}
+
+	return tokenBalanceList, nil
+}
+
+//NewSubForkContract 子链资产模型，合约地址为子链分支hash
+func NewSubForkContract(fork, token string) *openwallet.SmartContract {
+	contract := openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(Symbol, fork),
+		Symbol:     Symbol,
+		Address:    fork,
+		Token:      token,
+		Protocol:   "subfork",
+		Name:       token,
+		Decimals:   6,
+	}
+	return &contract
+}
+
+//getCoinAnchor 获取币种的anchor，子链资产返回子链分支hash，主链的fork为空
+func (wm *WalletManager) getCoinAnchor(coin openwallet.Coin) (anchor string, fork string, err error) {
+	if coin.IsContract {
+		if len(coin.Contract.Address) == 0 {
+			return "", "", fmt.Errorf("contract address is empty")
+		}
+		return coin.Contract.Address, coin.Contract.Address, nil
+	}
+
+	anchor, err = wm.getAnchor()
+	if err != nil {
+		return "", "", err
+	}
+	return anchor, "", nil
+}
+
+//getAnchorBlockHeight 获取anchor所在分支在节点上的当前高度
+func (wm *WalletManager) getAnchorBlockHeight(anchor string) (uint64, error) {
+
+	mainAnchor, err := wm.getAnchor()
+	if err != nil {
+		return 0, err
+	}
+
+	fork := anchor
+	if anchor == mainAnchor {
+		fork = ""
+	}
+	return wm.Client.getForkBlockHeight(fork)
+}
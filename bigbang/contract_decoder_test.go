@@ -22,3 +22,29 @@ func Test_GetTokenBalanceByAddress(t *testing.T) {
 		fmt.Println(balance[0])
 	}
 }
+
+func TestGetTokenBalanceByAddress_SubFork(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	fork := "00000a1b2c3d"
+	node.handle("getbalance", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		if params["fork"] != fork {
+			return []interface{}{}, nil
+		}
+		return []interface{}{map[string]interface{}{"fork": fork, "avail": "12.5"}}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	contract := NewSubForkContract(fork, "SUB")
+	balances, err := wm.ContractDecoder.GetTokenBalanceByAddress(*contract, "1address")
+	if err != nil {
+		t.Fatalf("GetTokenBalanceByAddress failed, unexpected error: %v", err)
+	}
+
+	if len(balances) != 1 || balances[0].Balance.Balance != "12.5" {
+		t.Errorf("balance of sub fork should be 12.5, got: %+v", balances)
+	}
+}
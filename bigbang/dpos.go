@@ -0,0 +1,346 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/go-owcdrivers/bigbangTransaction"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//DPoS交易类别，保存在交易单ExtParam的dpos
+const (
+	DPoSActionVote   = "vote"   //投票给委托人
+	DPoSActionRevoke = "revoke" //撤回投票
+	DPoSActionStake  = "stake"  //出块奖励
+)
+
+const (
+	voteTemplateBucket = "vote_template"
+	templateTypeVote   = "vote"
+)
+
+//VoteTemplate 投票模板地址，投票的资金锁定在模板地址，只能由投票人撤回
+type VoteTemplate struct {
+	Address   string `storm:"id"`
+	Delegate  string
+	Owner     string `storm:"index"`
+	AccountID string
+	CreateAt  int64
+}
+
+//VoteBalance 投票锁定的余额
+type VoteBalance struct {
+	Owner       string
+	Delegate    string
+	VoteAddress string
+	Balance     string
+}
+
+var (
+	//模板地址类型缓存
+	templateTypeCache sync.Map
+)
+
+//createVoteTemplate 创建投票模板地址，投票交易广播成功后才记录到本地
+func (wm *WalletManager) createVoteTemplate(delegate, owner string) (string, error) {
+
+	address, err := wm.Client.addNewTemplate(templateTypeVote, map[string]interface{}{
+		"delegate": delegate,
+		"owner":    owner,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	templateTypeCache.Store(address, templateTypeVote)
+
+	return address, nil
+}
+
+//saveVoteTemplate 记录投票模板地址
+func (wm *WalletManager) saveVoteTemplate(address, delegate, owner, accountID string) error {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.From(voteTemplateBucket).Save(&VoteTemplate{
+		Address:   address,
+		Delegate:  delegate,
+		Owner:     owner,
+		AccountID: accountID,
+		CreateAt:  time.Now().Unix(),
+	})
+}
+
+//saveSubmittedVoteTemplate 投票交易广播成功后记录投票模板地址
+func (wm *WalletManager) saveSubmittedVoteTemplate(rawTx *openwallet.RawTransaction) error {
+
+	if rawTx.GetExtParam().Get("dpos").String() != DPoSActionVote || len(rawTx.TxFrom) == 0 {
+		return nil
+	}
+
+	return wm.saveVoteTemplate(
+		rawTx.GetExtParam().Get("voteAddress").String(),
+		rawTx.GetExtParam().Get("voteDelegate").String(),
+		rawTx.TxFrom[0],
+		rawTx.Account.AccountID,
+	)
+}
+
+//GetVoteTemplate 获取本地记录的投票模板地址
+func (wm *WalletManager) GetVoteTemplate(address string) (*VoteTemplate, error) {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var record VoteTemplate
+	err = db.From(voteTemplateBucket).One("Address", address, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+//GetVoteTemplatesByOwner 获取投票人的所有投票模板地址
+func (wm *WalletManager) GetVoteTemplatesByOwner(owner string) ([]*VoteTemplate, error) {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*VoteTemplate
+	err = db.From(voteTemplateBucket).Find("Owner", owner, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//GetVotedBalanceByAddress 查询投票人地址投票锁定的余额，可用余额不包含这部分资金
+func (wm *WalletManager) GetVotedBalanceByAddress(owner ...string) ([]*VoteBalance, error) {
+
+	balances := make([]*VoteBalance, 0)
+
+	for _, addr := range owner {
+		templates, err := wm.GetVoteTemplatesByOwner(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tpl := range templates {
+			balance, err := wm.Client.getBalance(tpl.Address, "")
+			if err != nil {
+				return nil, err
+			}
+
+			balances = append(balances, &VoteBalance{
+				Owner:       tpl.Owner,
+				Delegate:    tpl.Delegate,
+				VoteAddress: tpl.Address,
+				Balance:     convertToAmount(balance.Balance.Uint64()),
+			})
+		}
+	}
+
+	return balances, nil
+}
+
+//getVotedBalances 统计投票人地址在分支上投票锁定的余额，没有投票的地址不访问节点
+func (wm *WalletManager) getVotedBalances(owner []string, anchor string) (map[string]uint64, error) {
+
+	voted := make(map[string]uint64)
+
+	for _, addr := range owner {
+		templates, err := wm.GetVoteTemplatesByOwner(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tpl := range templates {
+			balance, err := wm.Client.getBalance(tpl.Address, anchor)
+			if err != nil {
+				return nil, err
+			}
+			voted[addr] += balance.Balance.Uint64()
+		}
+	}
+
+	return voted, nil
+}
+
+//isVoteTemplate 是否投票模板地址
+func (wm *WalletManager) isVoteTemplate(address string) bool {
+
+	if !isTemplateAddress(address) {
+		return false
+	}
+
+	if tplType, ok := templateTypeCache.Load(address); ok {
+		return tplType.(string) == templateTypeVote
+	}
+
+	tplType, err := wm.Client.getTemplateType(address)
+	if err != nil {
+		wm.Log.Std.Info("can not get template type of address: %s; unexpected error: %v", address, err)
+		return false
+	}
+
+	templateTypeCache.Store(address, tplType)
+	return tplType == templateTypeVote
+}
+
+//getDPoSAction 识别DPoS相关的交易
+func (wm *WalletManager) getDPoSAction(trx *Transaction) string {
+	if trx.Type == TxTypeNameStake {
+		return DPoSActionStake
+	}
+	if wm.isVoteTemplate(trx.To) {
+		return DPoSActionVote
+	}
+	if wm.isVoteTemplate(trx.From) {
+		return DPoSActionRevoke
+	}
+	return ""
+}
+
+//CreateRevokeRawTransaction 创建撤回投票交易单，从投票模板地址转回投票人
+//ExtParam: dpos = revoke, voteAddress = 投票模板地址
+func (decoder *TransactionDecoder) CreateRevokeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	voteAddress := rawTx.GetExtParam().Get("voteAddress").String()
+	if len(voteAddress) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "vote address is empty")
+	}
+
+	tpl, err := decoder.wm.GetVoteTemplate(voteAddress)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "vote address: %s is not found", voteAddress)
+	}
+
+	ownerAddr, err := wrapper.GetAddress(tpl.Owner)
+	if err != nil {
+		return err
+	}
+
+	if ownerAddr.AccountID != rawTx.Account.AccountID {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "vote address: %s is not belong to account", voteAddress)
+	}
+
+	var amountStr, to string
+	for k, v := range rawTx.To {
+		to = k
+		amountStr = v
+		break
+	}
+
+	//投票的资金只能撤回给投票人
+	if to != tpl.Owner {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "revoked funds can only be sent to the vote owner: %s", tpl.Owner)
+	}
+
+	fee := decoder.wm.getTransactionFee(rawTx.FeeRate, nil)
+
+	sendAmount := convertFromAmount(amountStr)
+	amount := new(big.Int).SetUint64(sendAmount + fee)
+
+	anchor, err := decoder.wm.getAnchor()
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrUnknownException, "Fail to get anchor!")
+	}
+
+	utxos, err := decoder.wm.Client.listUnnSpent(voteAddress, anchor)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrUnknownException, "Failed to get utxo of address : [%s]!", voteAddress)
+	}
+
+	utxosInPool, err := decoder.wm.Client.getUTXOsInPool()
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrUnknownException, "Failed to get transactions in pool%s!", "")
+	}
+
+	vins := []bigbangTransaction.Vin{}
+	balanceSum := big.NewInt(0)
+	for _, utxo := range utxos {
+		if isUnspentAlreadyInPool(utxosInPool, utxo) {
+			continue
+		}
+		vins = append(vins, bigbangTransaction.Vin{
+			TxID: utxo.TxID,
+			Vout: utxo.Vout,
+		})
+		balanceSum.Add(balanceSum, new(big.Int).SetUint64(utxo.Amount))
+		if balanceSum.Cmp(amount) >= 0 {
+			break
+		}
+	}
+
+	if balanceSum.Cmp(amount) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the voted balance: %s is not enough!", amountStr)
+	}
+
+	//模板数据前2字节为模板类型
+	exported, err := decoder.wm.Client.exportTemplate(voteAddress)
+	if err != nil {
+		return err
+	}
+	tplBytes, err := hex.DecodeString(exported)
+	if err != nil || len(tplBytes) <= 2 {
+		return fmt.Errorf("invalid template data of address: %s", voteAddress)
+	}
+
+	emptyTrans, hash, err := createTemplateTransactionAndHash(TxTypeToken, bigbangTransaction.DefaultLockUntil, anchor, vins, to, sendAmount, fee, nil)
+	if err != nil {
+		return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
+	}
+
+	rawTx.TxFrom = []string{voteAddress}
+	rawTx.TxTo = []string{to}
+	rawTx.TxAmount = amountStr
+	rawTx.Fees = convertToAmount(fee)
+	rawTx.RawHex = emptyTrans
+	rawTx.SetExtParam("templateData", hex.EncodeToString(tplBytes[2:]))
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+
+	rawTx.Signatures[rawTx.Account.AccountID] = []*openwallet.KeySignature{
+		&openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "",
+			Address: ownerAddr,
+			Message: hash,
+		},
+	}
+
+	rawTx.FeeRate = big.NewInt(int64(fee)).String()
+
+	rawTx.IsBuilt = true
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package bigbang
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestGetDPoSAction(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	voteTpl := testEncodeAddress(addressPrefixTemplate, bytes.Repeat([]byte{0x21}, 32))
+	multisigTpl := testEncodeAddress(addressPrefixTemplate, bytes.Repeat([]byte{0x22}, 32))
+	pubkey := testEncodeAddress(addressPrefixPubkey, bytes.Repeat([]byte{0x23}, 32))
+
+	node.handle("validateaddress", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		template := map[string]string{voteTpl: templateTypeVote, multisigTpl: "multisig"}[params["address"].(string)]
+		return map[string]interface{}{"isvalid": true, "addressdata": map[string]interface{}{"template": template}}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	cases := []struct {
+		name string
+		trx  *Transaction
+		want string
+	}{
+		{"stake", &Transaction{Type: TxTypeNameStake, To: pubkey}, DPoSActionStake},
+		{"vote", &Transaction{Type: TxTypeNameToken, From: pubkey, To: voteTpl}, DPoSActionVote},
+		{"revoke", &Transaction{Type: TxTypeNameToken, From: voteTpl, To: pubkey}, DPoSActionRevoke},
+		{"other template", &Transaction{Type: TxTypeNameToken, From: pubkey, To: multisigTpl}, ""},
+		{"transfer", &Transaction{Type: TxTypeNameToken, From: pubkey, To: pubkey}, ""},
+	}
+
+	for _, c := range cases {
+		if got := wm.getDPoSAction(c.trx); got != c.want {
+			t.Errorf("%s: getDPoSAction got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	//模板类型缓存后不再查询节点
+	before := node.callCount("validateaddress")
+	wm.getDPoSAction(&Transaction{Type: TxTypeNameToken, From: pubkey, To: voteTpl})
+	if n := node.callCount("validateaddress") - before; n != 0 {
+		t.Errorf("template type should be cached, validateaddress called %d times", n)
+	}
+}
+
+//newDPoSTestWallet 创建投票测试的钱包，owner有一个10的输出，投票模板地址有一个3的输出
+func newDPoSTestWallet(t *testing.T, node *mockNode, owner, voteTpl string) (*WalletManager, *testWalletDAI) {
+	txid := strings.Repeat("01", 32)
+	node.handle("getbalance", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		balance := map[string]string{owner: "10", voteTpl: "3"}[params["address"].(string)]
+		return []interface{}{map[string]interface{}{"avail": balance}}, nil
+	})
+	node.handle("listunspent", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		amount := map[string]string{owner: "10", voteTpl: "3"}[params["address"].(string)]
+		return map[string]interface{}{"unspents": []interface{}{map[string]interface{}{"txid": txid, "out": 0, "amount": amount}}}, nil
+	})
+	node.handle("gettxpool", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"list": []interface{}{}}, nil
+	})
+	node.handle("addnewtemplate", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return voteTpl, nil
+	})
+	node.handle("exporttemplate", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return "0700" + strings.Repeat("ab", 64), nil
+	})
+	node.handle("sendtransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return "txid_vote", nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	wm.Config.Anchor = strings.Repeat("ab", 32)
+
+	wrapper := &testWalletDAI{addresses: []*openwallet.Address{{Address: owner, AccountID: "account"}}}
+	return wm, wrapper
+}
+
+func TestCreateVoteRawTransaction(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	owner := testEncodeAddress(addressPrefixPubkey, bytes.Repeat([]byte{0x31}, 32))
+	delegate := testEncodeAddress(addressPrefixPubkey, bytes.Repeat([]byte{0x32}, 32))
+	voteTpl := testEncodeAddress(addressPrefixTemplate, bytes.Repeat([]byte{0x33}, 32))
+
+	wm, wrapper := newDPoSTestWallet(t, node, owner, voteTpl)
+	defer closeTestWalletManager(wm)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: wm.Symbol()},
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{delegate: "5"},
+		FeeRate: "0.01",
+	}
+	rawTx.SetExtParam("dpos", DPoSActionVote)
+
+	if err := wm.TxDecoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction vote failed, unexpected error: %v", err)
+	}
+
+	if rawTx.TxTo[0] != voteTpl || rawTx.GetExtParam().Get("voteAddress").String() != voteTpl {
+		t.Errorf("vote should be sent to the vote template, got: %v", rawTx.TxTo)
+	}
+
+	//交易单未广播不记录投票模板
+	if _, err := wm.GetVoteTemplate(voteTpl); err == nil {
+		t.Errorf("vote template should not be saved before the transaction is submitted")
+	}
+	if balances, _ := wm.Blockscanner.GetBalanceDetailByAddress(owner); balances[0].VotedBalance != "0" {
+		t.Errorf("voted balance should be 0 before submitted, got: %s", balances[0].VotedBalance)
+	}
+
+	rawTx.IsCompleted = true
+	if _, err := wm.TxDecoder.SubmitRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("SubmitRawTransaction vote failed, unexpected error: %v", err)
+	}
+
+	tpl, err := wm.GetVoteTemplate(voteTpl)
+	if err != nil || tpl.Owner != owner || tpl.Delegate != delegate || tpl.AccountID != "account" {
+		t.Fatalf("vote template should be saved after submitted, got: %+v, %v", tpl, err)
+	}
+
+	//投票锁定的余额在地址余额明细中返回
+	balances, err := wm.Blockscanner.GetBalanceDetailByAddress(owner)
+	if err != nil {
+		t.Fatalf("GetBalanceDetailByAddress failed, unexpected error: %v", err)
+	}
+	if balances[0].VotedBalance != "3" || balances[0].ConfirmBalance != "10" {
+		t.Errorf("voted balance should be reported separately, got: %+v, voted: %s", balances[0].Balance, balances[0].VotedBalance)
+	}
+}
+
+func TestCreateRevokeRawTransaction(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	owner := testEncodeAddress(addressPrefixPubkey, bytes.Repeat([]byte{0x41}, 32))
+	other := testEncodeAddress(addressPrefixPubkey, bytes.Repeat([]byte{0x42}, 32))
+	voteTpl := testEncodeAddress(addressPrefixTemplate, bytes.Repeat([]byte{0x43}, 32))
+
+	wm, wrapper := newDPoSTestWallet(t, node, owner, voteTpl)
+	defer closeTestWalletManager(wm)
+
+	wm.saveVoteTemplate(voteTpl, other, owner, "account")
+
+	newRevoke := func(to string) *openwallet.RawTransaction {
+		rawTx := &openwallet.RawTransaction{
+			Coin:    openwallet.Coin{Symbol: wm.Symbol()},
+			Account: &openwallet.AssetsAccount{AccountID: "account"},
+			To:      map[string]string{to: "2"},
+			FeeRate: "0.01",
+		}
+		rawTx.SetExtParam("dpos", DPoSActionRevoke)
+		rawTx.SetExtParam("voteAddress", voteTpl)
+		return rawTx
+	}
+
+	//撤回的资金不能转给投票人以外的地址
+	if err := wm.TxDecoder.CreateRawTransaction(wrapper, newRevoke(other)); err == nil {
+		t.Errorf("revoke to another address should fail")
+	}
+
+	rawTx := newRevoke(owner)
+	if err := wm.TxDecoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction revoke failed, unexpected error: %v", err)
+	}
+
+	if rawTx.TxFrom[0] != voteTpl || rawTx.TxTo[0] != owner || len(rawTx.RawHex) == 0 {
+		t.Errorf("revoke should be sent from the vote template to the owner, got: %v -> %v", rawTx.TxFrom, rawTx.TxTo)
+	}
+	if rawTx.GetExtParam().Get("templateData").String() != strings.Repeat("ab", 64) {
+		t.Errorf("template data should be exported without type, got: %s", rawTx.GetExtParam().Get("templateData").String())
+	}
+	sigs := rawTx.Signatures["account"]
+	if len(sigs) != 1 || sigs[0].Address.Address != owner {
+		t.Errorf("revoke should be signed by the owner, got: %v", sigs)
+	}
+}
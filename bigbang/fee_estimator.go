@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"encoding/hex"
+)
+
+const (
+	//附加数据计费单位，每200字节
+	feeDataUnit = 200
+)
+
+//calcMinTxFee 节点的最低手续费规则，附加数据每200字节递增，超过1000字节后每单位费用翻倍
+func calcMinTxFee(dataLen int, minFee uint64) uint64 {
+	if dataLen == 0 {
+		return minFee
+	}
+
+	multiplier := uint64(dataLen / feeDataUnit)
+	if dataLen%feeDataUnit > 0 {
+		multiplier++
+	}
+
+	if multiplier > 5 {
+		return minFee + minFee*10 + (multiplier-5)*minFee*4
+	}
+	return minFee + multiplier*minFee*2
+}
+
+//EstimateFee 按附加数据估算交易手续费，开启节点查询时以节点返回为准，查询失败则按本地规则计算
+func (wm *WalletManager) EstimateFee(data []byte) uint64 {
+
+	if wm.Config.FeeFromNode {
+		fee, err := wm.Client.getTxFee(hex.EncodeToString(data))
+		if err == nil && fee > 0 {
+			return fee
+		}
+		wm.Log.Std.Info("can not get tx fee from node, use local fee rule; unexpected error: %v", err)
+	}
+
+	return calcMinTxFee(len(data), wm.Config.MinFee)
+}
+
+//getTransactionFee 交易手续费，优先使用交易单指定的手续费，其次是配置的固定手续费，最后按附加数据估算。
+//指定或固定的手续费低于附加数据对应的最低手续费时使用最低手续费，否则节点会拒绝交易
+func (wm *WalletManager) getTransactionFee(feeRate string, data []byte) uint64 {
+
+	var fee uint64
+	if len(feeRate) != 0 {
+		fee = convertFromAmount(feeRate)
+	} else if wm.Config.FixedFee > 0 {
+		fee = wm.Config.FixedFee
+	} else {
+		return wm.EstimateFee(data)
+	}
+
+	minFee := calcMinTxFee(len(data), wm.Config.MinFee)
+	if fee < minFee {
+		wm.Log.Std.Info("tx fee: %d is below the min fee: %d of %d bytes data, use the min fee", fee, minFee, len(data))
+		return minFee
+	}
+	return fee
+}
@@ -0,0 +1,67 @@
+package bigbang
+
+import (
+	"testing"
+)
+
+func TestCalcMinTxFee(t *testing.T) {
+	cases := []struct {
+		dataLen int
+		fee     uint64
+	}{
+		{0, 10000},
+		{1, 30000},
+		{200, 30000},
+		{201, 50000},
+		{1000, 110000},
+		{1001, 150000},
+		{1400, 190000},
+	}
+
+	for _, c := range cases {
+		if fee := calcMinTxFee(c.dataLen, 10000); fee != c.fee {
+			t.Errorf("data length: %d, expected fee: %d, got: %d", c.dataLen, c.fee, fee)
+		}
+	}
+}
+
+func TestGetTransactionFee(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettxfee", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"txfee": "0.05"}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	if fee := wm.getTransactionFee("", make([]byte, 300)); fee != 50000 {
+		t.Errorf("local fee rule expected: 50000, got: %d", fee)
+	}
+
+	wm.Config.FeeFromNode = true
+	if fee := wm.getTransactionFee("", []byte("memo")); fee != 50000 {
+		t.Errorf("node fee expected: 50000, got: %d", fee)
+	}
+
+	wm.Config.FixedFee = 100000
+	if fee := wm.getTransactionFee("", []byte("memo")); fee != 100000 {
+		t.Errorf("fixed fee expected: 100000, got: %d", fee)
+	}
+
+	if fee := wm.getTransactionFee("0.02", nil); fee != 20000 {
+		t.Errorf("specified fee expected: 20000, got: %d", fee)
+	}
+
+	//低于附加数据对应的最低手续费时使用最低手续费
+	if fee := wm.getTransactionFee("0.01", make([]byte, 300)); fee != 50000 {
+		t.Errorf("specified fee below the min fee expected: 50000, got: %d", fee)
+	}
+	if fee := wm.getTransactionFee("", make([]byte, 300)); fee != 100000 {
+		t.Errorf("fixed fee over the min fee expected: 100000, got: %d", fee)
+	}
+	if fee := wm.getTransactionFee("", make([]byte, 1200)); fee != 150000 {
+		t.Errorf("fixed fee below the min fee expected: 150000, got: %d", fee)
+	}
+}
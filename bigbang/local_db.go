@@ -0,0 +1,74 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/common/file"
+)
+
+const (
+	localDBFileName = "adapter.db" //适配器本地数据库文件名
+)
+
+//LocalDB 适配器本地数据库，保存广播记录等适配器自身维护的数据
+type LocalDB struct {
+	wm *WalletManager
+	mu sync.Mutex
+	db *storm.DB
+}
+
+//NewLocalDB 创建适配器本地数据库
+func NewLocalDB(wm *WalletManager) *LocalDB {
+	ldb := LocalDB{}
+	ldb.wm = wm
+	return &ldb
+}
+
+//Open 打开数据库，打开后保持打开状态供各模块共用
+func (ldb *LocalDB) Open() (*storm.DB, error) {
+	ldb.mu.Lock()
+	defer ldb.mu.Unlock()
+
+	if ldb.db != nil {
+		return ldb.db, nil
+	}
+
+	file.MkdirAll(ldb.wm.Config.dbPath)
+	dbFile := filepath.Join(ldb.wm.Config.dbPath, localDBFileName)
+	db, err := storm.Open(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	ldb.db = db
+	return ldb.db, nil
+}
+
+//Close 关闭数据库
+func (ldb *LocalDB) Close() error {
+	ldb.mu.Lock()
+	defer ldb.mu.Unlock()
+
+	if ldb.db == nil {
+		return nil
+	}
+	err := ldb.db.Close()
+	ldb.db = nil
+	return err
+}
@@ -18,6 +18,7 @@ package bigbang
 import (
 	"errors"
 	"path/filepath"
+	"sync"
 
 	"github.com/blocktree/openwallet/hdkeystore"
 	"github.com/blocktree/openwallet/log"
@@ -37,6 +38,13 @@ type WalletManager struct {
 	TxDecoder       openwallet.TransactionDecoder //交易单编码器
 	Log             *log.OWLogger                 //日志工具
 	ContractDecoder *ContractDecoder              //智能合约解析器
+	LocalDB         *LocalDB                      //适配器本地数据库
+	TxOutbox        *TxOutbox                     //广播交易发件箱
+
+	balanceCache *balanceCache //地址余额缓存
+
+	anchor   string     //主链anchor缓存
+	anchorMu sync.Mutex //anchor缓存锁
 }
 
 func NewWalletManager() *WalletManager {
@@ -52,6 +60,9 @@ func NewWalletManager() *WalletManager {
 	wm.TxDecoder = NewTransactionDecoder(&wm)
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.ContractDecoder = NewContractDecoder(&wm)
+	wm.LocalDB = NewLocalDB(&wm)
+	wm.TxOutbox = NewTxOutbox(&wm)
+	wm.balanceCache = newBalanceCache()
 
 	//	wm.RPCClient = NewRpcClient("http://localhost:20336/")
 	return &wm
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+//memo编码类型，保存在交易单ExtParam的memoType
+const (
+	MemoTypeText = "text" //UTF-8文本
+	MemoTypeHex  = "hex"  //十六进制字节
+	MemoTypeJSON = "json" //带type字段的JSON对象
+)
+
+const (
+	//附加数据长度使用单字节编码，最大252字节，json类型包含1字节的编码类型
+	MaxMemoLength = 252
+)
+
+//json类型附加数据的首字节，text和hex与其他钱包一样直接写入原始字节
+const memoPrefixJSON byte = 0x03
+
+//encodeMemo 按编码类型把memo转为交易附加数据，text为UTF-8字节，hex为解码后的字节，json在首字节标记编码类型
+func encodeMemo(memo, memoType string) ([]byte, error) {
+
+	if len(memo) == 0 {
+		return nil, nil
+	}
+
+	var data []byte
+
+	switch memoType {
+	case "", MemoTypeText:
+		if !utf8.ValidString(memo) {
+			return nil, fmt.Errorf("memo is not valid UTF-8 text")
+		}
+		data = []byte(memo)
+	case MemoTypeHex:
+		b, err := hex.DecodeString(strings.TrimPrefix(memo, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("memo is not valid hex: %v", err)
+		}
+		data = b
+	case MemoTypeJSON:
+		if !gjson.Valid(memo) || !gjson.Parse(memo).IsObject() {
+			return nil, fmt.Errorf("memo is not a JSON object")
+		}
+		if gjson.Get(memo, "type").Type != gjson.String {
+			return nil, fmt.Errorf("JSON memo must have a type field")
+		}
+		buf := new(bytes.Buffer)
+		if err := json.Compact(buf, []byte(memo)); err != nil {
+			return nil, err
+		}
+		data = append([]byte{memoPrefixJSON}, buf.Bytes()...)
+	default:
+		return nil, fmt.Errorf("unknown memo type: %s", memoType)
+	}
+
+	if len(data) > MaxMemoLength {
+		return nil, fmt.Errorf("memo length: %d is over the limit: %d bytes", len(data), MaxMemoLength)
+	}
+
+	return data, nil
+}
+
+//decodeMemo 把交易附加数据还原为memo及其编码类型。
+//有json首字节的还原为json，其他可显示的文本为text，否则为hex
+func decodeMemo(data []byte) (string, string) {
+
+	if len(data) == 0 {
+		return "", ""
+	}
+
+	if data[0] == memoPrefixJSON && gjson.ValidBytes(data[1:]) {
+		return string(data[1:]), MemoTypeJSON
+	}
+
+	if isPrintableText(data) {
+		return string(data), MemoTypeText
+	}
+
+	return hex.EncodeToString(data), MemoTypeHex
+}
+
+//decodeMemoHex 解析节点返回的附加数据hex，返回memo、编码类型及原始hex
+func decodeMemoHex(dataHex string) (string, string, string) {
+	data, err := hex.DecodeString(dataHex)
+	if err != nil {
+		return dataHex, MemoTypeText, hex.EncodeToString([]byte(dataHex))
+	}
+	memo, memoType := decodeMemo(data)
+	return memo, memoType, dataHex
+}
+
+//isPrintableText 是否可显示的UTF-8文本
+func isPrintableText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
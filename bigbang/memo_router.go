@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//MemoScanTargetFunc 按共享地址和memo查找充值所属源标识
+//@return 源标识，是否存在
+type MemoScanTargetFunc func(address, memo string) (string, bool)
+
+//SetMemoScanTargetFunc 设置共享地址按memo查找源标识的方法，配置了sharedAddresses时需要设置，否则共享地址的充值全部归入暂存账户
+func (bs *BBCBlockScanner) SetMemoScanTargetFunc(memoScanTargetFunc MemoScanTargetFunc) {
+	bs.memoScanTargetFunc = memoScanTargetFunc
+}
+
+//scanMemoTarget 按地址和memo查找源标识。
+//ScanTargetFunc按地址查找时共享地址总能命中，不能用于识别memo，没有设置MemoScanTargetFunc时不识别任何memo
+func (bs *BBCBlockScanner) scanMemoTarget(address, memo string) (string, bool) {
+	if bs.memoScanTargetFunc == nil {
+		bs.wm.Log.Std.Info("memo scan target func is not setup, memo: %s of shared address: %s can not be recognized", memo, address)
+		return "", false
+	}
+	return bs.memoScanTargetFunc(address, memo)
+}
+
+//getDepositSourceKey 查找收款方的源标识，共享地址按memo归属账户，无法识别的memo归入暂存账户
+func (bs *BBCBlockScanner) getDepositSourceKey(address, memo string, scanAddressFunc openwallet.BlockScanAddressFunc) (string, bool) {
+
+	if !bs.wm.Config.SharedAddresses[address] {
+		return scanAddressFunc(address)
+	}
+
+	if len(memo) > 0 {
+		if sourceKey, ok := bs.scanMemoTarget(address, memo); ok {
+			return sourceKey, true
+		}
+	}
+
+	if len(bs.wm.Config.SuspenseSourceKey) > 0 {
+		bs.wm.Log.Std.Info("deposit to shared address: %s with unknown memo: %s, credit to suspense account", address, memo)
+		return bs.wm.Config.SuspenseSourceKey, true
+	}
+
+	return scanAddressFunc(address)
+}
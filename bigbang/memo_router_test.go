@@ -0,0 +1,78 @@
+package bigbang
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestExtractTransaction_SharedAddress(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	anchor := "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+	node.handle("getblockhash", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return []string{anchor}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	shared := "1shared"
+	wm.Config.SharedAddresses = map[string]bool{shared: true}
+	wm.Config.SuspenseSourceKey = "suspense"
+
+	bs := wm.Blockscanner
+	bs.SetMemoScanTargetFunc(func(address, memo string) (string, bool) {
+		if address == shared && memo == "uid-1" {
+			return "account-1", true
+		}
+		return "", false
+	})
+
+	scanAddressFunc := func(address string) (string, bool) {
+		if address == shared {
+			return "owner", true
+		}
+		return "", false
+	}
+
+	cases := map[string]string{
+		"uid-1": "account-1",
+		"uid-2": "suspense",
+		"":      "suspense",
+	}
+
+	extract := func(memo string) map[string]*openwallet.TxExtractData {
+		trx := &Transaction{
+			TxID:   "txid",
+			Type:   TxTypeNameToken,
+			Anchor: anchor,
+			From:   "1other",
+			To:     shared,
+			Amount: 1000000,
+			Fee:    10000,
+			Memo:   hex.EncodeToString([]byte(memo)),
+		}
+
+		result := ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+		bs.extractTransaction(trx, &result, scanAddressFunc)
+		return result.extractData
+	}
+
+	for memo, expect := range cases {
+		if data := extract(memo); len(data) != 1 || data[expect] == nil {
+			t.Errorf("deposit with memo: %s should be credited to: %s, got: %v", memo, expect, data)
+		}
+	}
+
+	//没有设置按memo查找的方法时，按地址查找总能命中共享地址，不用于识别memo
+	bs.SetMemoScanTargetFunc(nil)
+	bs.ScanTargetFunc = func(target openwallet.ScanTarget) (string, bool) {
+		return "owner", target.Address == shared
+	}
+	if data := extract("uid-1"); len(data) != 1 || data["suspense"] == nil {
+		t.Errorf("deposit without memo scan target func should be credited to suspense, got: %v", data)
+	}
+}
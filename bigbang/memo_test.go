@@ -0,0 +1,85 @@
+package bigbang
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestMemoRoundTrip(t *testing.T) {
+	cases := []struct {
+		memo       string
+		memoType   string
+		expect     string
+		expectType string
+	}{
+		{"user-10086", MemoTypeText, "user-10086", MemoTypeText},
+		{"充值", "", "充值", MemoTypeText},
+		{`{ "type": "deposit", "uid": 1 }`, MemoTypeJSON, `{"type":"deposit","uid":1}`, MemoTypeJSON},
+		//hex直接写入原始字节，可显示的字节还原为文本
+		{"00ff01", MemoTypeHex, "00ff01", MemoTypeHex},
+		{"3132", MemoTypeHex, "12", MemoTypeText},
+		//像JSON的文本仍还原为文本
+		{`{"type":"deposit"}`, MemoTypeText, `{"type":"deposit"}`, MemoTypeText},
+	}
+
+	for _, c := range cases {
+		data, err := encodeMemo(c.memo, c.memoType)
+		if err != nil {
+			t.Errorf("encode memo: %s failed, unexpected error: %v", c.memo, err)
+			continue
+		}
+
+		memo, decodedType, memoHex := decodeMemoHex(hex.EncodeToString(data))
+		if memo != c.expect || decodedType != c.expectType || memoHex != hex.EncodeToString(data) {
+			t.Errorf("decode memo expected: %s(%s), got: %s(%s)", c.expect, c.expectType, memo, decodedType)
+		}
+	}
+
+	//text和hex与其他钱包一样不带编码类型首字节
+	if data, _ := encodeMemo("user-10086", MemoTypeText); string(data) != "user-10086" {
+		t.Errorf("text memo should be written raw, got: %x", data)
+	}
+	if data, _ := encodeMemo("00ff01", MemoTypeHex); hex.EncodeToString(data) != "00ff01" {
+		t.Errorf("hex memo should be written raw, got: %x", data)
+	}
+}
+
+func TestEncodeMemo_Invalid(t *testing.T) {
+	cases := []struct {
+		memo     string
+		memoType string
+	}{
+		{"zz", MemoTypeHex},
+		{`{"uid":1}`, MemoTypeJSON},
+		{`["deposit"]`, MemoTypeJSON},
+		{strings.Repeat("a", MaxMemoLength+1), MemoTypeText},
+		{"memo", "base64"},
+	}
+
+	for _, c := range cases {
+		if _, err := encodeMemo(c.memo, c.memoType); err == nil {
+			t.Errorf("memo: %s with type: %s should be invalid", c.memo, c.memoType)
+		}
+	}
+}
+
+func TestDecodeMemo_OtherWallet(t *testing.T) {
+	cases := []struct {
+		data     []byte
+		memo     string
+		memoType string
+	}{
+		{[]byte("uid-1"), "uid-1", MemoTypeText},
+		{[]byte(`{"type":"deposit"}`), `{"type":"deposit"}`, MemoTypeText},
+		{[]byte{0x00, 0xff}, "00ff", MemoTypeHex},
+	}
+
+	//其他钱包创建的交易按内容还原
+	for _, c := range cases {
+		memo, memoType := decodeMemo(c.data)
+		if memo != c.memo || memoType != c.memoType {
+			t.Errorf("decode memo expected: %s(%s), got: %s(%s)", c.memo, c.memoType, memo, memoType)
+		}
+	}
+}
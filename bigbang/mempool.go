@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+const (
+	memPoolBucket = "mempool"
+	//交易池记录保留时间，超时且已不在交易池的记录会被清理
+	memPoolTxExpired = 24 * time.Hour
+)
+
+//MemPoolTx 已从交易池提取的未确认交易
+type MemPoolTx struct {
+	TxID     string `storm:"id"`
+	Notified bool   //是否已通知未确认的充值
+	Anchor   string
+	Spent    []string    //花费的已监听地址输出txid:vout
+	Outputs  []*OutPoint //转入已监听地址的输出
+	CreateAt int64
+}
+
+//newMemPoolTx 交易池中的交易记录，inputs为解析到的输入，owned为已监听地址的输出
+func newMemPoolTx(trx *Transaction, inputs []*OutPoint, owned []*OutPoint) *MemPoolTx {
+	record := &MemPoolTx{
+		TxID:    trx.TxID,
+		Anchor:  trx.Anchor,
+		Outputs: owned,
+	}
+	for _, input := range inputs {
+		//节点没有返回vin时无法确定花费的输出
+		if len(input.TxID) > 0 {
+			record.Spent = append(record.Spent, input.ID)
+		}
+	}
+	return record
+}
+
+//GetTxIDsInMemPool 获取待处理的交易池中的交易单IDs
+func (wm *WalletManager) GetTxIDsInMemPool() ([]string, error) {
+	return wm.Client.getTxIDsInPool()
+}
+
+//GetTransactionInMemPool 获取交易池中的交易单
+func (wm *WalletManager) GetTransactionInMemPool(txid string) (*Transaction, error) {
+	return wm.Client.getTransaction(txid)
+}
+
+//getMemPoolTx 获取已提取的交易池交易记录
+func (wm *WalletManager) getMemPoolTx(txid string) (*MemPoolTx, error) {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var record MemPoolTx
+	err = db.From(memPoolBucket).One("TxID", txid, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+//saveMemPoolTx 记录已提取的交易池交易，避免每轮扫描重复通知
+func (wm *WalletManager) saveMemPoolTx(record *MemPoolTx) error {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	record.CreateAt = time.Now().Unix()
+	return db.From(memPoolBucket).Save(record)
+}
+
+//deleteMemPoolTx 交易已上链，删除交易池记录
+func (wm *WalletManager) deleteMemPoolTx(txid string) error {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	err = db.From(memPoolBucket).DeleteStruct(&MemPoolTx{TxID: txid})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//filterMemPoolTxIDs 过滤已提取的交易，并清理已不在交易池的过期记录
+func (wm *WalletManager) filterMemPoolTxIDs(txids []string) ([]string, error) {
+
+	db, err := wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*MemPoolTx
+	err = db.From(memPoolBucket).All(&records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	inPool := make(map[string]bool)
+	for _, txid := range txids {
+		inPool[txid] = true
+	}
+
+	extracted := make(map[string]bool)
+	expired := time.Now().Add(-memPoolTxExpired).Unix()
+	for _, record := range records {
+		if !inPool[record.TxID] && record.CreateAt < expired {
+			db.From(memPoolBucket).DeleteStruct(record)
+			continue
+		}
+		extracted[record.TxID] = true
+	}
+
+	newTxIDs := make([]string, 0)
+	for _, txid := range txids {
+		if !extracted[txid] {
+			newTxIDs = append(newTxIDs, txid)
+		}
+	}
+	return newTxIDs, nil
+}
@@ -0,0 +1,133 @@
+package bigbang
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//testObserver 记录扫描通知的观察者
+type testObserver struct {
+	mu      sync.Mutex
+	headers []*openwallet.BlockHeader
+	txs     []*openwallet.Transaction
+	err     error //不为空时通知交易失败
+}
+
+func (o *testObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.headers = append(o.headers, header)
+	return nil
+}
+
+func (o *testObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	o.txs = append(o.txs, data.Transaction)
+	return nil
+}
+
+func (o *testObserver) setError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.err = err
+}
+
+func (o *testObserver) transactions() []*openwallet.Transaction {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*openwallet.Transaction{}, o.txs...)
+}
+
+//newTestScanner 创建监听指定地址的扫描器，节点返回固定的anchor
+func newTestScanner(t *testing.T, node *mockNode, watched ...string) (*WalletManager, *testObserver) {
+	anchor := "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+	node.handle("getblockhash", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return []string{anchor}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	wm.Config.Anchor = anchor
+
+	addresses := make(map[string]bool)
+	for _, addr := range watched {
+		addresses[addr] = true
+	}
+	wm.Blockscanner.SetBlockScanAddressFunc(func(address string) (string, bool) {
+		if addresses[address] {
+			return "account", true
+		}
+		return "", false
+	})
+
+	observer := &testObserver{}
+	wm.Blockscanner.AddObserver(observer)
+	return wm, observer
+}
+
+//testTransaction 节点gettransaction返回的交易
+func testTransaction(txid, from, to string, confirmations int) map[string]interface{} {
+	return map[string]interface{}{
+		"transaction": map[string]interface{}{
+			"txid":          txid,
+			"type":          TxTypeNameToken,
+			"anchor":        "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071",
+			"sendfrom":      from,
+			"sendto":        to,
+			"amount":        "1.5",
+			"txfee":         "0.01",
+			"data":          "",
+			"confirmations": confirmations,
+		},
+	}
+}
+
+func TestScanTxMemPool(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	confirmations := 0
+	node.handle("gettxpool", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"list": []interface{}{map[string]interface{}{"hex": "txid_1"}}}, nil
+	})
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction("txid_1", "1sender", "1deposit", confirmations), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Blockscanner.IsScanMemPool = true
+
+	wm.Blockscanner.ScanTxMemPool()
+	//已提取的交易不重复通知
+	wm.Blockscanner.ScanTxMemPool()
+
+	txs := observer.transactions()
+	if len(txs) != 1 || !txs[0].GetExtParam().Get("pending").Bool() {
+		t.Fatalf("unconfirmed deposit should be notified once with pending status, got: %d", len(txs))
+	}
+
+	//交易上链后再次通知
+	confirmations = 1
+	err := wm.Blockscanner.BatchExtractTransaction(10, "block_hash", []string{"txid_1"}, false)
+	if err != nil {
+		t.Fatalf("BatchExtractTransaction failed, unexpected error: %v", err)
+	}
+
+	txs = observer.transactions()
+	if len(txs) != 2 {
+		t.Fatalf("confirmed deposit should be notified again, got: %d", len(txs))
+	}
+	if txs[1].GetExtParam().Get("pending").Bool() || !txs[1].GetExtParam().Get("wasPending").Bool() || txs[1].BlockHeight != 10 {
+		t.Errorf("confirmed deposit should be reconciled with the pending one, got ext: %s", txs[1].ExtParam)
+	}
+
+	if _, err := wm.getMemPoolTx("txid_1"); err == nil {
+		t.Errorf("mempool record should be deleted after confirmed")
+	}
+}
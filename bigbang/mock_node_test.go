@@ -0,0 +1,115 @@
+package bigbang
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+//mockNode 模拟节点的json-rpc接口
+type mockNode struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	handlers map[string]func(params map[string]interface{}) (interface{}, *mockRPCError)
+	calls    map[string]int
+
+	batchUnsupported bool //不支持批量请求，返回单个错误
+}
+
+type mockRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newMockNode() *mockNode {
+	node := &mockNode{
+		handlers: make(map[string]func(params map[string]interface{}) (interface{}, *mockRPCError)),
+		calls:    make(map[string]int),
+	}
+	node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		//批量请求
+		if len(body) > 0 && body[0] == '[' {
+			if node.batchUnsupported {
+				json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": nil, "error": &mockRPCError{Code: -32600, Message: "Invalid Request"}})
+				return
+			}
+			var requests []mockRPCRequest
+			json.Unmarshal(body, &requests)
+			responses := make([]map[string]interface{}, 0, len(requests))
+			for _, request := range requests {
+				responses = append(responses, node.serve(request))
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+
+		var request mockRPCRequest
+		json.Unmarshal(body, &request)
+		json.NewEncoder(w).Encode(node.serve(request))
+	}))
+	return node
+}
+
+type mockRPCRequest struct {
+	ID     interface{}            `json:"id"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+func (node *mockNode) serve(request mockRPCRequest) map[string]interface{} {
+	node.mu.Lock()
+	node.calls[request.Method]++
+	handler := node.handlers[request.Method]
+	node.mu.Unlock()
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+	if handler == nil {
+		resp["error"] = &mockRPCError{Code: -32601, Message: "Method not found"}
+	} else if result, rpcErr := handler(request.Params); rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+func (node *mockNode) handle(method string, handler func(params map[string]interface{}) (interface{}, *mockRPCError)) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.handlers[method] = handler
+}
+
+func (node *mockNode) callCount(method string) int {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.calls[method]
+}
+
+func (node *mockNode) Close() {
+	node.server.Close()
+}
+
+//newTestWalletManager 创建连接模拟节点的钱包管理者，数据保存在临时目录
+func newTestWalletManager(t *testing.T, node *mockNode) *WalletManager {
+	wm := NewWalletManager()
+	wm.Client = NewClient(node.server.URL, "", false)
+	dir, err := ioutil.TempDir("", "bbc-adapter-test")
+	if err != nil {
+		t.Fatalf("create temp dir failed, unexpected error: %v", err)
+	}
+	wm.Config.DataDir = dir
+	wm.Config.makeDataDir()
+	return wm
+}
+
+//closeTestWalletManager 关闭数据库并删除临时目录
+func closeTestWalletManager(wm *WalletManager) {
+	wm.LocalDB.Close()
+	os.RemoveAll(wm.Config.DataDir)
+}
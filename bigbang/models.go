@@ -32,6 +32,10 @@ type Block struct {
 	Timestamp             uint64
 	Height                uint64
 	Transactions          []string
+
+	txs        []*Transaction    //预取时已获取的交易
+	failures   []*ExtractFailure //预取时获取失败的交易单
+	txsFetched bool              //是否已获取交易
 }
 
 type Transaction struct {
@@ -47,25 +51,94 @@ type Transaction struct {
 	BlockHash       string
 	Confirmations   uint64
 	Memo            string
+	LockUntil       uint32 //接收方输出锁定到的区块高度
+	Vin             []TxVin
+}
+
+//TxVin 交易输入引用的上一个交易输出
+type TxVin struct {
+	TxID string
+	Vout uint8
+}
+
+//节点返回的交易类型
+const (
+	TxTypeNameToken   = "token"         //普通转账
+	TxTypeNameCert    = "certification" //委托人证书
+	TxTypeNameGenesis = "genesis"       //创世交易
+	TxTypeNameStake   = "stake"         //DPoS出块奖励
+	TxTypeNameWork    = "work"          //PoW出块奖励
+)
+
+//IsReward 是否奖励或创世交易，这类交易没有发送方
+func (trx *Transaction) IsReward() bool {
+	switch trx.Type {
+	case TxTypeNameGenesis, TxTypeNameStake, TxTypeNameWork:
+		return true
+	}
+	return false
 }
 
 func (c *Client)NewTransaction(json *gjson.Result) *Transaction {
+	return newTransaction(json.Get("transaction"))
+}
+
+//newTransaction 解析节点返回的交易对象
+func newTransaction(json gjson.Result) *Transaction {
 	obj := &Transaction{}
 
-	obj.TxID = json.Get("transaction").Get("txid").String()
-	obj.TimeStamp = json.Get("transaction").Get("time").Uint()
-	obj.Type = json.Get("transaction").Get("type").String()
-	obj.Anchor = json.Get("transaction").Get("anchor").String()
-	obj.From =  json.Get("transaction").Get("sendfrom").String()
-	obj.Amount = convertFromAmount(json.Get("transaction").Get("amount").String())
-	obj.Fee = convertFromAmount(json.Get("transaction").Get("txfee").String())
-	obj.To = json.Get("transaction").Get("sendto").String()
-	obj.Confirmations = json.Get("transaction").Get("confirmations").Uint()
-	obj.Memo = json.Get("transaction").Get("data").String()
+	obj.TxID = json.Get("txid").String()
+	obj.TimeStamp = json.Get("time").Uint()
+	obj.Type = json.Get("type").String()
+	obj.Anchor = json.Get("anchor").String()
+	obj.From =  json.Get("sendfrom").String()
+	obj.Amount = convertFromAmount(json.Get("amount").String())
+	obj.Fee = convertFromAmount(json.Get("txfee").String())
+	obj.To = json.Get("sendto").String()
+	obj.Confirmations = json.Get("confirmations").Uint()
+	obj.Memo = json.Get("data").String()
+	obj.LockUntil = uint32(json.Get("lockuntil").Uint())
+
+	for _, vin := range json.Get("vin").Array() {
+		obj.Vin = append(obj.Vin, TxVin{
+			TxID: vin.Get("txid").String(),
+			Vout: uint8(vin.Get("vout").Uint()),
+		})
+	}
 
 	return obj
 }
 
+//NewBlockDetail 解析getblockdetail返回的区块及其全部交易，出块奖励交易排在最前
+func NewBlockDetail(json *gjson.Result) (*Block, []*Transaction) {
+	obj := &Block{}
+
+	obj.Hash = json.Get("hash").String()
+	obj.PrevBlockHash = json.Get("hashPrev").String()
+	obj.Fork = json.Get("fork").String()
+	obj.Timestamp = json.Get("time").Uint()
+	obj.Height = json.Get("height").Uint()
+
+	txs := make([]*Transaction, 0)
+	if mint := json.Get("txmint"); mint.IsObject() {
+		obj.TransactionMerkleRoot = mint.Get("txid").String()
+		txs = append(txs, newTransaction(mint))
+	}
+
+	for _, tx := range json.Get("tx").Array() {
+		trx := newTransaction(tx)
+		obj.Transactions = append(obj.Transactions, trx.TxID)
+		txs = append(txs, trx)
+	}
+
+	for _, trx := range txs {
+		trx.BlockHeight = obj.Height
+		trx.BlockHash = obj.Hash
+	}
+
+	return obj, txs
+}
+
 func NewBlock(json *gjson.Result) *Block {
 	obj := &Block{}
 
@@ -83,6 +156,15 @@ func NewBlock(json *gjson.Result) *Block {
 	return obj
 }
 
+//TxIDs 区块的全部交易单，出块奖励交易不在Transactions中，放在最前
+func (b *Block) TxIDs() []string {
+	txids := make([]string, 0, len(b.Transactions)+1)
+	if len(b.TransactionMerkleRoot) > 0 {
+		txids = append(txids, b.TransactionMerkleRoot)
+	}
+	return append(txids, b.Transactions...)
+}
+
 //BlockHeader 区块链头
 func (b *Block) BlockHeader() *openwallet.BlockHeader {
 
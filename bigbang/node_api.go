@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 
 	//"math/big"
 
@@ -60,6 +61,8 @@ func NewClient(url, token string, debug bool) *Client {
 	}
 
 	api := req.New()
+	//提前创建http客户端，req是在首次请求时才创建，并发请求会产生竞争
+	api.Client()
 	//trans, _ := api.Client().Transport.(*http.Transport)
 	//trans.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	c.client = api
@@ -165,12 +168,91 @@ func isError(result *gjson.Result) error {
 	return err
 }
 
+// CallBatch 批量调用同一方法，一次请求多个json-rpc，结果和错误按请求顺序返回。
+// 单个请求出错只记录在对应的errs中，不影响其他请求的结果，整个请求失败才返回err
+func (c *Client) CallBatch(path string, requests []map[string]interface{}) ([]*gjson.Result, []error, error) {
+
+	if c.client == nil {
+		return nil, nil, errors.New("API url is not setup. ")
+	}
+
+	if len(requests) == 0 {
+		return []*gjson.Result{}, []error{}, nil
+	}
+
+	authHeader := req.Header{
+		"Accept":        "application/json",
+		"Authorization": "Basic " + c.AccessToken,
+	}
+
+	body := make([]map[string]interface{}, 0, len(requests))
+	for i, request := range requests {
+		body = append(body, map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      i,
+			"method":  path,
+			"params":  request,
+		})
+	}
+
+	r, err := c.client.Post(c.BaseURL, req.BodyJSON(&body), authHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp := gjson.ParseBytes(r.Bytes())
+	if !resp.IsArray() {
+		//节点不支持批量请求时返回单个错误
+		if err := isError(&resp); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("batch response is not an array")
+	}
+
+	results := make([]*gjson.Result, len(requests))
+	errs := make([]error, len(requests))
+	for _, item := range resp.Array() {
+		item := item
+		id := int(item.Get("id").Int())
+		if id < 0 || id >= len(requests) {
+			continue
+		}
+		if err := isError(&item); err != nil {
+			errs[id] = err
+			continue
+		}
+		result := item.Get("result")
+		results[id] = &result
+	}
+
+	for i, result := range results {
+		if result == nil && errs[i] == nil {
+			errs[i] = errors.New("batch response is missing")
+		}
+	}
+
+	return results, errs, nil
+}
+
+//isMethodNotFound 节点是否不支持该方法
+func isMethodNotFound(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "[-32601]")
+}
+
 // 获取当前区块高度
 func (c *Client) getBlockHeight() (uint64, error) {
+	return c.getForkBlockHeight("")
+}
+
+// 获取指定分支的当前区块高度，fork为空则为主链
+func (c *Client) getForkBlockHeight(fork string) (uint64, error) {
 
 	path := "getblockcount"
 	request := map[string]interface{}{
 	}
+	if len(fork) > 0 {
+		request["fork"] = fork
+	}
 	resp, err := c.Call(path, request)
 
 	if err != nil {
@@ -243,6 +325,9 @@ func (c *Client) getBalance(address, anchor string) (*AddrBalance, error) {
 	request := map[string]interface{}{
 		"address":address,
 	}
+	if len(anchor) > 0 {
+		request["fork"] = anchor
+	}
 
 	resp, err := c.Call(path, request)
 
@@ -254,12 +339,18 @@ func (c *Client) getBalance(address, anchor string) (*AddrBalance, error) {
 		return &AddrBalance{
 			Address:address,
 			Balance:big.NewInt(0),
+			Locked:big.NewInt(0),
+			Unconfirmed:big.NewInt(0),
 		}, nil
 	}
 
+	//avail为可花费余额，locked为锁定余额，unconfirmed为交易池中未确认的余额
+	balance := resp.Array()[0]
 	return &AddrBalance{
 		Address:address,
-		Balance:big.NewInt(int64(convertFromAmount(resp.Array()[0].Get("avail").String()))),
+		Balance:big.NewInt(int64(convertFromAmount(balance.Get("avail").String()))),
+		Locked:big.NewInt(int64(convertFromAmount(balance.Get("locked").String()))),
+		Unconfirmed:big.NewInt(int64(convertFromAmount(balance.Get("unconfirmed").String()))),
 	}, nil
 }
 
@@ -267,6 +358,7 @@ type UnSpent struct {
 	TxID string
 	Vout byte
 	Amount uint64
+	LockUntil uint32 //锁定到的区块高度，0为不锁定
 }
 
 func (c *Client) listUnnSpent (address, anchor string) ([]UnSpent, error) {
@@ -296,6 +388,7 @@ func (c *Client) listUnnSpent (address, anchor string) ([]UnSpent, error) {
 				TxID:   utxo.Get("txid").String(),
 				Vout:   byte(utxo.Get("out").Uint()),
 				Amount: convertFromAmount(utxo.Get("amount").String()),
+				LockUntil: uint32(utxo.Get("lockuntil").Uint()),
 			})
 		}
 	}
@@ -309,12 +402,20 @@ type UTXOinPool struct {
 }
 
 func (c *Client) getUTXOsInPool() ([]UTXOinPool, error) {
+	return c.getForkUTXOsInPool("")
+}
+
+// 获取指定分支交易池中已被花费的UTXO，fork为空则为主链
+func (c *Client) getForkUTXOsInPool(fork string) ([]UTXOinPool, error) {
 	ret := make([]UTXOinPool, 0)
 	path := "gettxpool"
 
 	request := map[string]interface{}{
 		"detail":true,
 	}
+	if len(fork) > 0 {
+		request["fork"] = fork
+	}
 
 	resp, err := c.Call(path, request)
 	if err != nil {
@@ -348,6 +449,27 @@ func (c *Client) getUTXOsInPool() ([]UTXOinPool, error) {
 	return ret, nil
 }
 
+// 获取交易池中的交易单ID
+func (c *Client) getTxIDsInPool() ([]string, error) {
+	ret := make([]string, 0)
+	path := "gettxpool"
+
+	request := map[string]interface{}{
+		"detail": true,
+	}
+
+	resp, err := c.Call(path, request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range resp.Get("list").Array() {
+		ret = append(ret, tx.Get("hex").String())
+	}
+
+	return ret, nil
+}
+
 func isUnspentAlreadyInPool(utxos []UTXOinPool, utxo UnSpent) bool {
 	if utxos == nil || len(utxos) == 0 {
 		return false
@@ -367,11 +489,19 @@ func (c *Client) getBlock(hash string) (*Block, error) {
 }
 
 func (c *Client) getBlockByHeight(height uint64) (*Block, error) {
+	return c.getForkBlockByHeight(height, "")
+}
+
+// 获取指定分支的区块，fork为空则为主链
+func (c *Client) getForkBlockByHeight(height uint64, fork string) (*Block, error) {
 
 	path := "getblockhash"
 	request := map[string]interface{}{
 		"height":height,
 	}
+	if len(fork) > 0 {
+		request["fork"] = fork
+	}
 
 	resp, err := c.Call(path, request)
 
@@ -395,6 +525,51 @@ func (c *Client) getBlockByHeight(height uint64) (*Block, error) {
 	return NewBlock(resp), nil
 }
 
+// 获取区块及其全部交易详情
+func (c *Client) getBlockDetail(hash string) (*Block, []*Transaction, error) {
+	path := "getblockdetail"
+	request := map[string]interface{}{
+		"block": hash,
+	}
+
+	resp, err := c.Call(path, request)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, txs := NewBlockDetail(resp)
+	return block, txs, nil
+}
+
+// 批量获取交易，获取失败的交易单不影响其他交易，按顺序返回在failures中
+func (c *Client) getTransactions(txids []string) ([]*Transaction, []*ExtractFailure, error) {
+	path := "gettransaction"
+	requests := make([]map[string]interface{}, 0, len(txids))
+	for _, txid := range txids {
+		requests = append(requests, map[string]interface{}{
+			"txid":       txid,
+			"serialized": false,
+		})
+	}
+
+	results, errs, err := c.CallBatch(path, requests)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txs := make([]*Transaction, 0, len(results))
+	failures := make([]*ExtractFailure, 0)
+	for i, result := range results {
+		if errs[i] != nil {
+			failures = append(failures, &ExtractFailure{TxID: txids[i], Reason: errs[i].Error()})
+			continue
+		}
+		txs = append(txs, c.NewTransaction(result))
+	}
+	return txs, failures, nil
+}
+
 func (c *Client) getTransaction(txid string) (*Transaction, error) {
 	path := "gettransaction"
 	request := map[string]interface{}{
@@ -424,7 +599,12 @@ func (c *Client) getAnchor() (string, error) {
 		return "", err
 	}
 
-	return  resp.Array()[0].String(), nil
+	hashes := resp.Array()
+	if len(hashes) == 0 {
+		return "", fmt.Errorf("genesis block hash is not found")
+	}
+
+	return hashes[0].String(), nil
 }
 
 func (c *Client) sendTransaction(rawTx string) (string, error) {
@@ -444,6 +624,96 @@ func (c *Client) sendTransaction(rawTx string) (string, error) {
 
 }
 
+// 解析交易单，获取交易单ID
+func (c *Client) decodeTransactionID(rawTx string) (string, error) {
+	path := "decodetransaction"
+
+	request := map[string]interface{}{
+		"txdata": rawTx,
+	}
+
+	resp, err := c.Call(path, request)
+
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Get("txid").String(), nil
+}
+
+// 查询节点对附加数据要求的交易手续费
+func (c *Client) getTxFee(hexData string) (uint64, error) {
+	path := "gettxfee"
+
+	request := map[string]interface{}{
+		"hexdata": hexData,
+	}
+
+	resp, err := c.Call(path, request)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return convertFromAmount(resp.Get("txfee").String()), nil
+}
+
+// 创建模板地址
+func (c *Client) addNewTemplate(tplType string, data map[string]interface{}) (string, error) {
+	path := "addnewtemplate"
+
+	request := map[string]interface{}{
+		"type": tplType,
+		tplType: data,
+	}
+
+	resp, err := c.Call(path, request)
+
+	if err != nil {
+		return "", err
+	}
+
+	return resp.String(), nil
+}
+
+// 导出模板数据
+func (c *Client) exportTemplate(address string) (string, error) {
+	path := "exporttemplate"
+
+	request := map[string]interface{}{
+		"address": address,
+	}
+
+	resp, err := c.Call(path, request)
+
+	if err != nil {
+		return "", err
+	}
+
+	return resp.String(), nil
+}
+
+// 获取模板地址的模板类型，非模板地址返回空
+func (c *Client) getTemplateType(address string) (string, error) {
+	path := "validateaddress"
+
+	request := map[string]interface{}{
+		"address": address,
+	}
+
+	resp, err := c.Call(path, request)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !resp.Get("isvalid").Bool() {
+		return "", fmt.Errorf("invalid address: %s", address)
+	}
+
+	return resp.Get("addressdata.template").String(), nil
+}
+
 func (c *Client) getContractAccountBalence(regid, address string) (*AddrBalance, error) {
 	return nil, errors.New("Contract is not supported!")
 }
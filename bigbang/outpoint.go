@@ -0,0 +1,283 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/asdine/storm"
+)
+
+const (
+	outPointBucket       = "outpoint"
+	outPointSourceBucket = "outpointsource"
+	maxOutPointDepth     = 8 //单次提取向节点追溯找零金额的最大交易深度
+)
+
+//errOutPointTooDeep 找零追溯超过maxOutPointDepth层
+var errOutPointTooDeep = errors.New("outpoint: change is too deep to resolve")
+
+//BigBang交易最多两个输出
+const (
+	VoutTo     = uint8(0) //转给接收方
+	VoutChange = uint8(1) //找零回发送方
+)
+
+//OutPoint 交易输出，记录已解析的输出地址和金额，用于计算引用它的输入金额
+type OutPoint struct {
+	ID      string `storm:"id"` //txid:vout
+	TxID    string
+	Vout    uint8
+	Address string
+	Amount  uint64
+}
+
+//OutPointSource 追溯找零时已向节点查询、输入尚未解析的来源交易，下次追溯从这里继续
+type OutPointSource struct {
+	TxID   string `storm:"id"`
+	Type   string
+	From   string
+	To     string
+	Amount uint64
+	Fee    uint64
+	Vin    []TxVin
+}
+
+func (source *OutPointSource) transaction() *Transaction {
+	return &Transaction{
+		TxID:   source.TxID,
+		Type:   source.Type,
+		From:   source.From,
+		To:     source.To,
+		Amount: source.Amount,
+		Fee:    source.Fee,
+		Vin:    source.Vin,
+	}
+}
+
+func outPointID(txid string, vout uint8) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+//txVouts 交易的输出，vout0为接收方，vout1为找零，输入总额扣除转账金额和手续费后大于0才有找零
+func txVouts(trx *Transaction, inputAmount uint64) []*OutPoint {
+	vouts := []*OutPoint{
+		&OutPoint{ID: outPointID(trx.TxID, VoutTo), TxID: trx.TxID, Vout: VoutTo, Address: trx.To, Amount: trx.Amount},
+	}
+	if inputAmount > trx.Amount+trx.Fee {
+		vouts = append(vouts, &OutPoint{
+			ID:      outPointID(trx.TxID, VoutChange),
+			TxID:    trx.TxID,
+			Vout:    VoutChange,
+			Address: trx.From,
+			Amount:  inputAmount - trx.Amount - trx.Fee,
+		})
+	}
+	return vouts
+}
+
+//outPointResolver 一次提取中解析输入引用的输出，已查询的来源交易和输出不重复查询
+type outPointResolver struct {
+	save    bool //是否记录查询到的输出
+	txs     map[string]*Transaction
+	points  map[string]*OutPoint
+	fetched []*Transaction //本次向节点查询的来源交易
+}
+
+//getTxInputs 解析交易全部输入引用的输出，返回输入总额。
+//节点没有返回vin时，按转账金额加手续费作为输入总额，不产生找零。save为false时不记录查询到的输出。
+//找零追溯超过maxOutPointDepth层时同样按转账金额加手续费计算，并记录已查询的来源交易，下次从这些交易继续追溯。
+func (bs *BBCBlockScanner) getTxInputs(trx *Transaction, save bool) ([]*OutPoint, uint64, error) {
+	resolver := &outPointResolver{
+		save:   save,
+		txs:    make(map[string]*Transaction),
+		points: make(map[string]*OutPoint),
+	}
+	inputs, amount, err := bs.resolveTxInputs(trx, 0, resolver)
+	if err == errOutPointTooDeep {
+		bs.wm.Log.Std.Info("transaction: %s change is too deep to resolve, use amount and fee as input", trx.TxID)
+		if save {
+			//输出已解析的来源交易不需要记录
+			sources := make([]*Transaction, 0, len(resolver.fetched))
+			for _, prev := range resolver.fetched {
+				if _, ok := resolver.points[outPointID(prev.TxID, VoutTo)]; !ok {
+					sources = append(sources, prev)
+				}
+			}
+			bs.saveOutPointSources(sources)
+		}
+		return nil, trx.Amount + trx.Fee, nil
+	}
+	return inputs, amount, err
+}
+
+//resolveTxInputs 解析交易输入，depth为已向节点追溯来源交易的层数
+func (bs *BBCBlockScanner) resolveTxInputs(trx *Transaction, depth int, resolver *outPointResolver) ([]*OutPoint, uint64, error) {
+
+	if trx.IsReward() {
+		return nil, 0, nil
+	}
+
+	if len(trx.Vin) == 0 {
+		return nil, trx.Amount + trx.Fee, nil
+	}
+
+	var total uint64
+	inputs := make([]*OutPoint, 0, len(trx.Vin))
+	for _, vin := range trx.Vin {
+		point, err := bs.getOutPoint(vin.TxID, vin.Vout, depth, resolver)
+		if err != nil {
+			return nil, 0, err
+		}
+		inputs = append(inputs, point)
+		total += point.Amount
+	}
+	return inputs, total, nil
+}
+
+//getOutPoint 获取交易输出，本地没有记录时向节点查询来源交易，找零金额需继续追溯来源交易的输入。
+//之前追溯时记录的来源交易不计入层数，向节点追溯超过maxOutPointDepth层返回errOutPointTooDeep。
+func (bs *BBCBlockScanner) getOutPoint(txid string, vout uint8, depth int, resolver *outPointResolver) (*OutPoint, error) {
+
+	id := outPointID(txid, vout)
+	if point, ok := resolver.points[id]; ok {
+		return point, nil
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var point OutPoint
+	err = db.From(outPointBucket).One("ID", id, &point)
+	if err == nil {
+		resolver.points[id] = &point
+		return &point, nil
+	}
+	if err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	stored := false
+	prev, ok := resolver.txs[txid]
+	if !ok {
+		var source OutPointSource
+		err = db.From(outPointSourceBucket).One("TxID", txid, &source)
+		if err != nil && err != storm.ErrNotFound {
+			return nil, err
+		}
+		if err == nil {
+			prev, stored = source.transaction(), true
+		} else {
+			if depth >= maxOutPointDepth {
+				return nil, errOutPointTooDeep
+			}
+			prev, err = bs.wm.GetTransaction(txid)
+			if err != nil {
+				return nil, err
+			}
+			resolver.fetched = append(resolver.fetched, prev)
+			depth++
+		}
+		resolver.txs[txid] = prev
+	}
+
+	//只需要接收方输出时不追溯来源交易的输入
+	var inputAmount uint64
+	if vout != VoutTo {
+		_, inputAmount, err = bs.resolveTxInputs(prev, depth, resolver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vouts := txVouts(prev, inputAmount)
+	if resolver.save {
+		bs.saveOutPoints(vouts)
+		if stored {
+			bs.deleteOutPointSource(txid)
+		}
+	}
+
+	for _, p := range vouts {
+		resolver.points[p.ID] = p
+	}
+
+	if p, ok := resolver.points[id]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("outpoint: %s is not found", id)
+}
+
+//saveOutPoints 记录交易输出
+func (bs *BBCBlockScanner) saveOutPoints(points []*OutPoint) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not open db; unexpected error: %v", err)
+		return
+	}
+
+	for _, point := range points {
+		err = db.From(outPointBucket).Save(point)
+		if err != nil {
+			bs.wm.Log.Std.Error("block scanner can not save outpoint: %s; unexpected error: %v", point.ID, err)
+		}
+	}
+}
+
+//saveOutPointSources 记录追溯找零时查询到的来源交易
+func (bs *BBCBlockScanner) saveOutPointSources(txs []*Transaction) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not open db; unexpected error: %v", err)
+		return
+	}
+
+	for _, trx := range txs {
+		source := &OutPointSource{
+			TxID:   trx.TxID,
+			Type:   trx.Type,
+			From:   trx.From,
+			To:     trx.To,
+			Amount: trx.Amount,
+			Fee:    trx.Fee,
+			Vin:    trx.Vin,
+		}
+		err = db.From(outPointSourceBucket).Save(source)
+		if err != nil {
+			bs.wm.Log.Std.Error("block scanner can not save outpoint source: %s; unexpected error: %v", trx.TxID, err)
+		}
+	}
+}
+
+//deleteOutPointSource 来源交易的输出已记录后删除
+func (bs *BBCBlockScanner) deleteOutPointSource(txid string) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not open db; unexpected error: %v", err)
+		return
+	}
+
+	err = db.From(outPointSourceBucket).DeleteStruct(&OutPointSource{TxID: txid})
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not delete outpoint source: %s; unexpected error: %v", txid, err)
+	}
+}
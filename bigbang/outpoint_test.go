@@ -0,0 +1,237 @@
+package bigbang
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//testVinTransaction 节点gettransaction返回的带vin的交易
+func testVinTransaction(txid, from, to, amount string, vin ...string) map[string]interface{} {
+	tx := testTransaction(txid, from, to, 3)
+	trx := tx["transaction"].(map[string]interface{})
+	trx["amount"] = amount
+	vins := make([]interface{}, 0)
+	for i := 0; i+1 < len(vin); i += 2 {
+		vout := 0
+		if vin[i+1] == "1" {
+			vout = 1
+		}
+		vins = append(vins, map[string]interface{}{"txid": vin[i], "vout": vout})
+	}
+	trx["vin"] = vins
+	return tx
+}
+
+//sumAmount 汇总输入或输出金额
+func sumAmount(amounts ...string) decimal.Decimal {
+	sum := decimal.Zero
+	for _, amount := range amounts {
+		d, _ := decimal.NewFromString(amount)
+		sum = sum.Add(d)
+	}
+	return sum
+}
+
+func TestExtractTransaction_ChangeAndSelfTransfer(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	txs := map[string]map[string]interface{}{
+		"txid_a": testVinTransaction("txid_a", "1sender", "1hot", "10", "txid_ext", "0"),
+		"txid_b": testVinTransaction("txid_b", "1hot", "1user", "3", "txid_a", "0"),
+		"txid_c": testVinTransaction("txid_c", "1hot", "1hot", "2", "txid_b", "1"),
+	}
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return txs[params["txid"].(string)], nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1hot")
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	//本地没有来源交易的输出，向节点追溯找零金额
+	result := bs.ExtractTransaction(12, "hash_12", "txid_c", bs.ScanAddressFunc, false)
+	if !result.Success {
+		t.Fatalf("ExtractTransaction failed: %s", result.Reason)
+	}
+
+	data := result.extractData["account"]
+	if len(data.TxInputs) != 1 || data.TxInputs[0].SourceTxID != "txid_b" || data.TxInputs[0].SourceIndex != 1 || data.TxInputs[0].Amount != "6.99" {
+		t.Fatalf("input should spend the change of txid_b, got: %+v", data.TxInputs)
+	}
+
+	if len(data.TxOutputs) != 2 || data.TxOutputs[0].Amount != "2" || data.TxOutputs[1].Index != 1 || data.TxOutputs[1].Amount != "4.98" || data.TxOutputs[1].Address != "1hot" {
+		t.Fatalf("self transfer should have the transfer and change outputs, got: %d", len(data.TxOutputs))
+	}
+
+	if !data.Transaction.GetExtParam().Get("selfTransfer").Bool() {
+		t.Errorf("self transfer should be marked")
+	}
+
+	//输入总额等于输出总额加手续费
+	inputs := make([]string, 0)
+	for _, input := range data.TxInputs {
+		inputs = append(inputs, input.Amount)
+	}
+	outputs := []string{data.Transaction.Fees}
+	for _, output := range data.TxOutputs {
+		outputs = append(outputs, output.Amount)
+	}
+	if !sumAmount(inputs...).Equal(sumAmount(outputs...)) {
+		t.Errorf("inputs should reconcile with outputs and fee, got inputs: %v, outputs: %v", inputs, outputs)
+	}
+
+	//已解析的输出保存在本地，不再向节点查询
+	calls := node.callCount("gettransaction")
+	result = bs.ExtractTransaction(11, "hash_11", "txid_b", bs.ScanAddressFunc, false)
+	if node.callCount("gettransaction") != calls+1 {
+		t.Errorf("resolved outpoints should be cached")
+	}
+
+	data = result.extractData["account"]
+	if len(data.TxOutputs) != 1 || data.TxOutputs[0].Index != 1 || data.TxOutputs[0].Amount != "6.99" {
+		t.Errorf("withdraw should only record the change output to the sender, got: %d", len(data.TxOutputs))
+	}
+	if data.Transaction.To[0] != "1user:3" || data.Transaction.To[1] != "1hot:6.99" {
+		t.Errorf("transaction should list all outputs, got: %v", data.Transaction.To)
+	}
+}
+
+func TestExtractTransaction_DepositWithoutVin(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction("txid_1", "1sender", "1deposit", 3), nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	result := bs.ExtractTransaction(10, "hash_10", "txid_1", bs.ScanAddressFunc, false)
+	var data *openwallet.TxExtractData = result.extractData["account"]
+	if data == nil || len(data.TxInputs) != 0 || len(data.TxOutputs) != 1 || data.TxOutputs[0].Amount != "1.5" {
+		t.Fatalf("deposit should only record the output to the watched address")
+	}
+}
+
+func TestGetTxInputs_DepthAndMemo(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	//txid_0转入1hot，之后每笔交易花费上一笔的找零
+	txs := map[string]map[string]interface{}{
+		"txid_0": testTransaction("txid_0", "1sender", "1hot", 3),
+	}
+	for i := 1; i <= maxOutPointDepth+2; i++ {
+		vout := "1"
+		if i == 1 {
+			vout = "0"
+		}
+		txid := fmt.Sprintf("txid_%d", i)
+		txs[txid] = testVinTransaction(txid, "1hot", "1user", "0.01", fmt.Sprintf("txid_%d", i-1), vout)
+	}
+	//同时花费同一来源交易的两个输出
+	txs["txid_pair"] = testVinTransaction("txid_pair", "1hot", "1hot", "0.5", "txid_0", "0")
+	txs["txid_both"] = testVinTransaction("txid_both", "1hot", "1user", "0.01", "txid_pair", "0", "txid_pair", "1")
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return txs[params["txid"].(string)], nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1hot")
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	inputAmount := func(result ExtractResult) string {
+		inputs := result.extractData["account"].TxInputs
+		if len(inputs) != 1 {
+			t.Fatalf("withdraw should have one input, got: %d", len(inputs))
+		}
+		return inputs[0].Amount
+	}
+
+	//追溯超过上限时按转账金额加手续费计算，节点查询次数有上限
+	tip := fmt.Sprintf("txid_%d", maxOutPointDepth+2)
+	result := bs.ExtractTransaction(20, "hash_20", tip, bs.ScanAddressFunc, false)
+	if !result.Success {
+		t.Fatalf("ExtractTransaction failed: %s", result.Reason)
+	}
+	if amount := inputAmount(result); !sumAmount(amount).Equal(sumAmount("0.01", "0.01")) {
+		t.Errorf("input should fall back to amount and fee, got: %s", amount)
+	}
+	if n := node.callCount("gettransaction"); n > maxOutPointDepth+1 {
+		t.Errorf("node queries should be bounded by the depth limit, got: %d", n)
+	}
+
+	//下次从已记录的来源交易继续追溯，得到准确的找零
+	calls := node.callCount("gettransaction")
+	result = bs.ExtractTransaction(20, "hash_20", tip, bs.ScanAddressFunc, false)
+	if !result.Success {
+		t.Fatalf("ExtractTransaction failed: %s", result.Reason)
+	}
+	//txid_0转入1.5，之后每笔花费0.01加手续费0.01
+	change := sumAmount("1.5").Sub(sumAmount("0.02").Mul(decimal.New(maxOutPointDepth+1, 0)))
+	if amount := inputAmount(result); !sumAmount(amount).Equal(change) {
+		t.Errorf("input should be the resolved change %s, got: %s", change, amount)
+	}
+	//tip、txid_1、txid_0各一次
+	if n := node.callCount("gettransaction") - calls; n != 3 {
+		t.Errorf("stored source transactions should not be queried again, got: %d", n)
+	}
+	db, _ := wm.LocalDB.Open()
+	if n, _ := db.From(outPointSourceBucket).Count(&OutPointSource{}); n != 0 {
+		t.Errorf("resolved source transactions should be deleted, got: %d", n)
+	}
+
+	//同一来源交易只查询一次
+	calls = node.callCount("gettransaction")
+	result = bs.ExtractTransaction(20, "hash_20", "txid_both", bs.ScanAddressFunc, false)
+	if !result.Success {
+		t.Fatalf("ExtractTransaction failed: %s", result.Reason)
+	}
+	//txid_both、txid_pair各一次，txid_0的输出已记录
+	if n := node.callCount("gettransaction") - calls; n != 2 {
+		t.Errorf("source transaction should be queried once, got: %d", n)
+	}
+	if inputs := result.extractData["account"].TxInputs; len(inputs) != 2 {
+		t.Errorf("both outputs of the source transaction should be spent, got: %d", len(inputs))
+	}
+}
+
+func TestExtractTransactionData_ReadOnly(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	txs := map[string]map[string]interface{}{
+		"txid_a": testVinTransaction("txid_a", "1sender", "1hot", "10", "txid_ext", "0"),
+		"txid_b": testVinTransaction("txid_b", "1hot", "1user", "3", "txid_a", "0"),
+	}
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return txs[params["txid"].(string)], nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1hot")
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	scanTargetFunc := func(target openwallet.ScanTarget) (string, bool) {
+		return "account", target.Address == "1hot"
+	}
+	data, err := bs.ExtractTransactionData("txid_b", scanTargetFunc)
+	if err != nil || len(data["account"]) != 1 || len(data["account"][0].TxInputs) != 1 {
+		t.Fatalf("ExtractTransactionData should extract the withdraw, got: %v, %v", data, err)
+	}
+
+	//查询接口不写入本地输出和UTXO
+	db, _ := wm.LocalDB.Open()
+	if n, _ := db.From(outPointBucket).Count(&OutPoint{}); n != 0 {
+		t.Errorf("ExtractTransactionData should not save outpoints, got: %d", n)
+	}
+	if n, _ := db.From(utxoBucket).Count(&UTXO{}); n != 0 {
+		t.Errorf("ExtractTransactionData should not save utxos, got: %d", n)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"fmt"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	blockTxsBucket = "block_txs"
+)
+
+//BlockTxs 区块中已提取的交易单，分叉时通知观察者哪些交易已失效
+type BlockTxs struct {
+	Hash   string `storm:"id"`
+	Fork   string `storm:"index"` //所属子链，主链为空
+	Height uint64 `storm:"index"`
+	TxIDs  []string
+}
+
+//ReorgObserver 区块分叉的观察者
+type ReorgObserver interface {
+
+	//OrphanBlockNotify 孤块通知，txids为该区块已提取通知过的交易单
+	OrphanBlockNotify(header *openwallet.BlockHeader, txids []string) error
+
+	//ReorgAlertNotify 分叉深度超过上限或本地缺少区块无法校验共同祖先时告警，扫描器停止在当前高度等待人工处理，处理后调用ClearReorgHalt恢复
+	ReorgAlertNotify(header *openwallet.BlockHeader, depth uint64) error
+}
+
+//AddReorgObserver 添加分叉观察者
+func (bs *BBCBlockScanner) AddReorgObserver(obj ReorgObserver) {
+	bs.reorgMu.Lock()
+	defer bs.reorgMu.Unlock()
+	if obj == nil {
+		return
+	}
+	if bs.reorgObservers == nil {
+		bs.reorgObservers = make(map[ReorgObserver]bool)
+	}
+	bs.reorgObservers[obj] = true
+}
+
+//RemoveReorgObserver 移除分叉观察者
+func (bs *BBCBlockScanner) RemoveReorgObserver(obj ReorgObserver) {
+	bs.reorgMu.Lock()
+	defer bs.reorgMu.Unlock()
+	delete(bs.reorgObservers, obj)
+}
+
+//rollbackToCommonAncestor 从本地最新区块往回查找与节点一致的共同祖先，通知所有孤块并重置扫描起点
+func (bs *BBCBlockScanner) rollbackToCommonAncestor(tipHeight uint64, tipHash string) (*openwallet.BlockHeader, error) {
+	return bs.rollbackForkToCommonAncestor("", tipHeight, tipHash)
+}
+
+//rollbackForkToCommonAncestor 回退分支到共同祖先，fork为空则为主链
+func (bs *BBCBlockScanner) rollbackForkToCommonAncestor(fork string, tipHeight uint64, tipHash string) (*openwallet.BlockHeader, error) {
+
+	var (
+		orphans = make([]*Block, 0)
+		height  = tipHeight
+		local   = &Block{Hash: tipHash, Height: tipHeight}
+	)
+
+	if block, err := bs.getForkLocalBlock(fork, tipHeight); err == nil && block.Hash == tipHash {
+		local = block
+	}
+
+	ancestor := &openwallet.BlockHeader{}
+
+	for {
+		nodeBlock, err := bs.wm.Client.getForkBlockByHeight(height, fork)
+		if err != nil {
+			return nil, err
+		}
+
+		if nodeBlock.Hash == local.Hash {
+			ancestor.Height = local.Height
+			ancestor.Hash = local.Hash
+			break
+		}
+
+		orphans = append(orphans, local)
+
+		if uint64(len(orphans)) > bs.wm.Config.MaxReorgDepth {
+			depth := uint64(len(orphans))
+			bs.haltReorg(fork, local, depth)
+			return nil, fmt.Errorf("block reorg depth is over the limit: %d", bs.wm.Config.MaxReorgDepth)
+		}
+
+		if height == 0 {
+			bs.haltReorg(fork, local, uint64(len(orphans)))
+			return nil, fmt.Errorf("can not find common ancestor block")
+		}
+		height--
+
+		local, err = bs.getForkLocalBlock(fork, height)
+		if err != nil {
+			if err != storm.ErrNotFound {
+				return nil, err
+			}
+			//本地没有更早的区块记录，无法校验节点的区块是否为共同祖先
+			bs.haltReorg(fork, orphans[len(orphans)-1], uint64(len(orphans)))
+			return nil, fmt.Errorf("can not find local block on height: %d to verify common ancestor", height)
+		}
+	}
+
+	//重新记录一个新扫描起点
+	err := bs.saveForkScannedBlockHeader(fork, ancestor.Height, ancestor.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, orphan := range orphans {
+		bs.wm.Log.Std.Info("orphan block on fork: %s height: %d, hash: %s .", fork, orphan.Height, orphan.Hash)
+
+		//删除孤块的未扫记录
+		bs.deleteForkUnscanRecord(fork, orphan.Height)
+
+		txids, err := bs.getBlockTxIDs(orphan.Hash)
+		if err != nil {
+			bs.wm.Log.Std.Error("can not get extracted txs of orphan block: %s; unexpected error: %v", orphan.Hash, err)
+		}
+
+		//通知分叉区块给观测者，异步处理，子链区块没有通知过新区块
+		if len(fork) == 0 {
+			bs.newBlockNotify(orphan, true)
+		}
+		bs.orphanBlockNotify(orphan.BlockHeader(), txids)
+		bs.deleteBlockTxIDs(orphan.Hash)
+		bs.deleteHeldExtracts(orphan.Hash)
+		bs.deleteAddressTxs(orphan.Hash)
+		bs.deleteBlockUTXOs(orphan.Hash)
+	}
+
+	//回滚的交易涉及的地址不确定，清空余额缓存
+	bs.wm.balanceCache.reset()
+
+	return ancestor, nil
+}
+
+//haltReorg 分叉无法自动回退，停止扫描该分支并告警，告警只通知一次，直到人工处理后ClearReorgHalt
+func (bs *BBCBlockScanner) haltReorg(fork string, orphan *Block, depth uint64) {
+	bs.reorgMu.Lock()
+	if bs.reorgHalted == nil {
+		bs.reorgHalted = make(map[string]bool)
+	}
+	halted := bs.reorgHalted[fork]
+	bs.reorgHalted[fork] = true
+	bs.reorgMu.Unlock()
+
+	if halted {
+		return
+	}
+
+	bs.wm.Log.Std.Error("block reorg depth: %d can not be rolled back with the limit: %d on fork: %s height: %d, scanner stopped, please check the node and rescan manually", depth, bs.wm.Config.MaxReorgDepth, fork, orphan.Height)
+	bs.reorgAlertNotify(orphan.BlockHeader(), depth)
+}
+
+//isReorgHalted 分支是否因分叉停止扫描
+func (bs *BBCBlockScanner) isReorgHalted(fork string) bool {
+	bs.reorgMu.RLock()
+	defer bs.reorgMu.RUnlock()
+	return bs.reorgHalted[fork]
+}
+
+//ClearReorgHalt 人工处理分叉后恢复分支的扫描，fork为空则为主链
+func (bs *BBCBlockScanner) ClearReorgHalt(fork string) {
+	bs.reorgMu.Lock()
+	defer bs.reorgMu.Unlock()
+	delete(bs.reorgHalted, fork)
+}
+
+//saveForkScannedBlockHeader 记录分支的已扫高度，fork为空则为主链
+func (bs *BBCBlockScanner) saveForkScannedBlockHeader(fork string, height uint64, hash string) error {
+	if len(fork) == 0 {
+		return bs.SaveLocalNewBlock(height, hash)
+	}
+	return bs.SaveSubForkScannedBlockHeader(fork, height, hash)
+}
+
+//saveForkStartBlock 扫描起点没有本地区块记录时，从节点获取同一区块记录并固定为已扫高度，分叉回退不会越过该区块
+func (bs *BBCBlockScanner) saveForkStartBlock(fork string, header *openwallet.BlockHeader) error {
+
+	_, err := bs.getForkLocalBlock(fork, header.Height)
+	if err == nil {
+		return nil
+	}
+	if err != storm.ErrNotFound {
+		return err
+	}
+
+	block, err := bs.wm.Client.getForkBlockByHeight(header.Height, fork)
+	if err != nil {
+		return err
+	}
+
+	//节点在起点高度已是其他区块，不记录，由分叉回退处理
+	if block.Hash != header.Hash {
+		return nil
+	}
+
+	err = bs.saveForkLocalBlock(fork, block)
+	if err != nil {
+		return err
+	}
+
+	return bs.saveForkScannedBlockHeader(fork, block.Height, block.Hash)
+}
+
+//orphanBlockNotify 通知孤块给分叉观察者
+func (bs *BBCBlockScanner) orphanBlockNotify(header *openwallet.BlockHeader, txids []string) {
+	bs.reorgMu.RLock()
+	defer bs.reorgMu.RUnlock()
+
+	header.Fork = true
+	for o := range bs.reorgObservers {
+		err := o.OrphanBlockNotify(header, txids)
+		if err != nil {
+			bs.wm.Log.Error("OrphanBlockNotify unexpected error:", err)
+		}
+	}
+}
+
+//reorgAlertNotify 通知分叉深度超限告警
+func (bs *BBCBlockScanner) reorgAlertNotify(header *openwallet.BlockHeader, depth uint64) {
+	bs.reorgMu.RLock()
+	defer bs.reorgMu.RUnlock()
+
+	for o := range bs.reorgObservers {
+		err := o.ReorgAlertNotify(header, depth)
+		if err != nil {
+			bs.wm.Log.Error("ReorgAlertNotify unexpected error:", err)
+		}
+	}
+}
+
+//saveBlockTxIDs 记录区块中已提取的交易单，并清理同一分支超过分叉深度的旧记录
+func (bs *BBCBlockScanner) saveBlockTxIDs(fork string, height uint64, hash string, txids []string) error {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	err = db.From(blockTxsBucket).Save(&BlockTxs{Hash: hash, Fork: fork, Height: height, TxIDs: txids})
+	if err != nil {
+		return err
+	}
+
+	if height > bs.wm.Config.MaxReorgDepth {
+		query := db.From(blockTxsBucket).Select(q.Eq("Fork", fork), q.Lt("Height", height-bs.wm.Config.MaxReorgDepth))
+		err = query.Delete(&BlockTxs{})
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+//getBlockTxIDs 获取区块中已提取的交易单
+func (bs *BBCBlockScanner) getBlockTxIDs(hash string) ([]string, error) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var record BlockTxs
+	err = db.From(blockTxsBucket).One("Hash", hash, &record)
+	if err == storm.ErrNotFound {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.TxIDs, nil
+}
+
+//deleteBlockTxIDs 删除区块中已提取的交易单记录
+func (bs *BBCBlockScanner) deleteBlockTxIDs(hash string) error {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	err = db.From(blockTxsBucket).DeleteStruct(&BlockTxs{Hash: hash})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
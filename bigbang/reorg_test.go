@@ -0,0 +1,287 @@
+package bigbang
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//testBlockchainDAI 内存中的区块链数据访问接口，区块头和未扫记录按symbol分开保存
+type testBlockchainDAI struct {
+	openwallet.BlockchainDAIBase
+	mu      sync.Mutex
+	current *openwallet.BlockHeader
+	blocks  map[string]*openwallet.BlockHeader //symbol_height -> header
+	saved   []uint64                           //保存主链区块的高度顺序
+	unscans []*openwallet.UnscanRecord
+}
+
+func newTestBlockchainDAI() *testBlockchainDAI {
+	return &testBlockchainDAI{blocks: make(map[string]*openwallet.BlockHeader)}
+}
+
+//blockKey 区块头的key，没有symbol的为主链
+func (dai *testBlockchainDAI) blockKey(symbol string, height uint64) string {
+	if len(symbol) == 0 {
+		symbol = Symbol
+	}
+	return fmt.Sprintf("%s_%d", symbol, height)
+}
+
+func (dai *testBlockchainDAI) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.current = header
+	return nil
+}
+
+func (dai *testBlockchainDAI) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	if dai.current == nil {
+		return nil, storm.ErrNotFound
+	}
+	return dai.current, nil
+}
+
+func (dai *testBlockchainDAI) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.blocks[dai.blockKey(header.Symbol, header.Height)] = header
+	if len(header.Symbol) == 0 || header.Symbol == Symbol {
+		dai.saved = append(dai.saved, header.Height)
+	}
+	return nil
+}
+
+func (dai *testBlockchainDAI) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	header, ok := dai.blocks[dai.blockKey(symbol, height)]
+	if !ok {
+		return nil, storm.ErrNotFound
+	}
+	return header, nil
+}
+
+func (dai *testBlockchainDAI) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	for i, r := range dai.unscans {
+		if r.ID == record.ID {
+			dai.unscans[i] = record
+			return nil
+		}
+	}
+	dai.unscans = append(dai.unscans, record)
+	return nil
+}
+
+func (dai *testBlockchainDAI) DeleteUnscanRecordByID(id string, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	for i, r := range dai.unscans {
+		if r.ID == id {
+			dai.unscans = append(dai.unscans[:i], dai.unscans[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (dai *testBlockchainDAI) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	list := make([]*openwallet.UnscanRecord, 0, len(dai.unscans))
+	for _, r := range dai.unscans {
+		if r.BlockHeight != height || r.Symbol != symbol {
+			list = append(list, r)
+		}
+	}
+	dai.unscans = list
+	return nil
+}
+
+func (dai *testBlockchainDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	list := make([]*openwallet.UnscanRecord, 0)
+	for _, r := range dai.unscans {
+		if r.Symbol == symbol {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+//testReorgObserver 记录孤块和告警通知
+type testReorgObserver struct {
+	orphans map[string][]string
+	alerts  []uint64
+}
+
+func (o *testReorgObserver) OrphanBlockNotify(header *openwallet.BlockHeader, txids []string) error {
+	o.orphans[header.Hash] = txids
+	return nil
+}
+
+func (o *testReorgObserver) ReorgAlertNotify(header *openwallet.BlockHeader, depth uint64) error {
+	o.alerts = append(o.alerts, depth)
+	return nil
+}
+
+//testChain 模拟节点的区块链，hashes[i]为高度i的区块hash
+type testChain struct {
+	mu     sync.Mutex
+	hashes []string
+	txs    map[string][]string
+}
+
+func (chain *testChain) setup(node *mockNode) {
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+		return len(chain.hashes), nil
+	})
+	node.handle("getblockhash", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+		height := int(params["height"].(float64))
+		if height >= len(chain.hashes) {
+			return nil, &mockRPCError{Code: -6, Message: "Block number out of range."}
+		}
+		return []string{chain.hashes[height]}, nil
+	})
+	node.handle("getblock", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+		hash := params["block"].(string)
+		for height, h := range chain.hashes {
+			if h != hash {
+				continue
+			}
+			prev := ""
+			if height > 0 {
+				prev = chain.hashes[height-1]
+			}
+			txs := chain.txs[hash]
+			if txs == nil {
+				txs = []string{}
+			}
+			return map[string]interface{}{"hash": hash, "hashPrev": prev, "height": height, "tx": txs}, nil
+		}
+		return nil, &mockRPCError{Code: -6, Message: "Unknown block."}
+	})
+}
+
+//newReorgTestScanner 本地已扫描a0~a5，节点在高度4开始分叉为b4~b6
+func newReorgTestScanner(t *testing.T, node *mockNode) (*WalletManager, *testBlockchainDAI, *testReorgObserver) {
+	chain := &testChain{
+		hashes: []string{"a0", "a1", "a2", "a3", "b4", "b5", "b6"},
+	}
+	chain.setup(node)
+
+	wm := newTestWalletManager(t, node)
+	wm.Config.Anchor = "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+
+	dai := newTestBlockchainDAI()
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockScanAddressFunc(func(address string) (string, bool) { return "", false })
+	bs.Scanning = true
+
+	for i := 0; i <= 5; i++ {
+		prev := ""
+		if i > 0 {
+			prev = fmt.Sprintf("a%d", i-1)
+		}
+		dai.SaveLocalBlockHead(&openwallet.BlockHeader{Height: uint64(i), Hash: fmt.Sprintf("a%d", i), Previousblockhash: prev})
+	}
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Height: 5, Hash: "a5"})
+	bs.saveBlockTxIDs("", 4, "a4", []string{"tx_a4"})
+	bs.saveBlockTxIDs("", 5, "a5", []string{"tx_a5_1", "tx_a5_2"})
+
+	observer := &testReorgObserver{orphans: make(map[string][]string)}
+	bs.AddReorgObserver(observer)
+
+	return wm, dai, observer
+}
+
+func TestScanBlockTask_DeepReorg(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, dai, observer := newReorgTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+
+	wm.Blockscanner.ScanBlockTask()
+
+	if len(observer.orphans) != 2 || len(observer.orphans["a4"]) != 1 || len(observer.orphans["a5"]) != 2 {
+		t.Errorf("orphan blocks a4 and a5 should be notified with extracted txids, got: %v", observer.orphans)
+	}
+
+	if dai.current.Height != 6 || dai.current.Hash != "b6" {
+		t.Errorf("scanner should rescan the new branch to b6, got: %d %s", dai.current.Height, dai.current.Hash)
+	}
+
+	if len(observer.alerts) != 0 {
+		t.Errorf("reorg within the limit should not alert")
+	}
+}
+
+func TestScanBlockTask_ReorgDepthExceeded(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, dai, observer := newReorgTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+	wm.Config.MaxReorgDepth = 1
+
+	wm.Blockscanner.ScanBlockTask()
+
+	if len(observer.alerts) != 1 || observer.alerts[0] != 2 {
+		t.Errorf("reorg over the limit should alert once, got: %v", observer.alerts)
+	}
+
+	if len(observer.orphans) != 0 || dai.current.Height != 5 || dai.current.Hash != "a5" {
+		t.Errorf("scanner should stay on the local head, got: %d %s", dai.current.Height, dai.current.Hash)
+	}
+
+	//停止扫描后不再重复告警
+	calls := node.callCount("getblockhash")
+	wm.Blockscanner.ScanBlockTask()
+	if len(observer.alerts) != 1 || node.callCount("getblockhash") != calls {
+		t.Errorf("halted scanner should not scan or alert again, got alerts: %v", observer.alerts)
+	}
+
+	//人工处理后恢复扫描
+	wm.Config.MaxReorgDepth = 100
+	wm.Blockscanner.ClearReorgHalt("")
+	wm.Blockscanner.ScanBlockTask()
+	if dai.current.Height != 6 || dai.current.Hash != "b6" {
+		t.Errorf("scanner should resume after clearing the halt, got: %d %s", dai.current.Height, dai.current.Hash)
+	}
+}
+
+func TestScanBlockTask_ReorgMissingLocalBlock(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, dai, observer := newReorgTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+
+	//共同祖先a3没有本地记录，不能以节点区块为准
+	delete(dai.blocks, dai.blockKey("", 3))
+
+	wm.Blockscanner.ScanBlockTask()
+
+	if len(observer.alerts) != 1 || len(observer.orphans) != 0 {
+		t.Errorf("missing local block should alert without rollback, got alerts: %v, orphans: %v", observer.alerts, observer.orphans)
+	}
+	if dai.current.Height != 5 || dai.current.Hash != "a5" {
+		t.Errorf("scanner should stay on the local head, got: %d %s", dai.current.Height, dai.current.Hash)
+	}
+}
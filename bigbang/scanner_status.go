@@ -0,0 +1,228 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//统计扫描速度的时间窗口
+var statusWindows = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+//ScannerStatus 扫描器状态
+type ScannerStatus struct {
+	LocalHeight       uint64             //已扫描高度
+	NodeHeight        uint64             //节点最新高度
+	Lag               uint64             //落后节点的区块数
+	BlocksPerSecond   map[string]float64 //各时间窗口内每秒扫描的区块数
+	TxsPerSecond      map[string]float64 //各时间窗口内每秒扫描的交易数
+	UnscanRecords     int                //等待重扫的记录数
+	DeadUnscanRecords int                //超过重扫次数的死信记录数
+	Errors            uint64             //扫描出错次数
+	LastError         string             //最近一次扫描错误
+	LastErrorTime     int64              //最近一次扫描错误的时间
+	LastBlockTime     int64              //最近一次扫描到新区块的时间
+}
+
+type scanEvent struct {
+	at  time.Time
+	txs int
+}
+
+//scanStats 扫描统计
+type scanStats struct {
+	mu            sync.Mutex
+	events        []scanEvent
+	localHeight   uint64
+	errors        uint64
+	lastError     string
+	lastErrorTime int64
+	lastBlockTime int64
+}
+
+func newScanStats() *scanStats {
+	return &scanStats{}
+}
+
+//recordBlock 记录已扫描的区块
+func (s *scanStats) recordBlock(height uint64, txs int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.localHeight = height
+	s.lastBlockTime = now.Unix()
+	s.events = append(s.events, scanEvent{at: now, txs: txs})
+
+	//只保留最大时间窗口内的记录
+	maxWindow := statusWindows[len(statusWindows)-1].Duration
+	i := 0
+	for i < len(s.events) && now.Sub(s.events[i].at) > maxWindow {
+		i++
+	}
+	s.events = s.events[i:]
+}
+
+//recordError 记录扫描错误
+func (s *scanStats) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors++
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now().Unix()
+}
+
+//fill 填充统计数据到扫描器状态
+func (s *scanStats) fill(status *ScannerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	status.LocalHeight = s.localHeight
+	status.Errors = s.errors
+	status.LastError = s.lastError
+	status.LastErrorTime = s.lastErrorTime
+	status.LastBlockTime = s.lastBlockTime
+	status.BlocksPerSecond = make(map[string]float64)
+	status.TxsPerSecond = make(map[string]float64)
+
+	for _, window := range statusWindows {
+		blocks, txs := 0, 0
+		for _, event := range s.events {
+			if now.Sub(event.at) <= window.Duration {
+				blocks++
+				txs += event.txs
+			}
+		}
+		seconds := window.Duration.Seconds()
+		status.BlocksPerSecond[window.Name] = float64(blocks) / seconds
+		status.TxsPerSecond[window.Name] = float64(txs) / seconds
+	}
+}
+
+//GetScannerStatus 获取扫描器的进度、落后区块数、扫描速度、未扫记录数和最近的错误
+func (bs *BBCBlockScanner) GetScannerStatus() *ScannerStatus {
+
+	status := &ScannerStatus{}
+	bs.stats.fill(status)
+
+	//本次运行还未扫描区块时使用本地记录的高度
+	if status.LocalHeight == 0 {
+		if height, _, err := bs.GetLocalNewBlock(); err == nil {
+			status.LocalHeight = height
+		}
+	}
+
+	nodeHeight, err := bs.wm.GetBlockHeight()
+	if err == nil {
+		bs.setTipHeight("", nodeHeight)
+	} else if value, ok := bs.tipHeights.Load(""); ok {
+		nodeHeight = value.(uint64)
+	}
+	status.NodeHeight = nodeHeight
+
+	if status.NodeHeight > status.LocalHeight {
+		status.Lag = status.NodeHeight - status.LocalHeight
+	}
+
+	if records, err := bs.getAllUnscanRecords(); err == nil {
+		status.UnscanRecords = len(records)
+	}
+
+	if dead, err := bs.GetDeadUnscanRecords(); err == nil {
+		status.DeadUnscanRecords = len(dead)
+	}
+
+	return status
+}
+
+//metricsText 以Prometheus文本格式输出扫描器状态
+func (bs *BBCBlockScanner) metricsText() []byte {
+
+	status := bs.GetScannerStatus()
+	prefix := strings.ToLower(bs.wm.Symbol()) + "_scanner_"
+
+	var buf bytes.Buffer
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n# TYPE %s%s gauge\n%s%s %v\n", prefix, name, help, prefix, name, prefix, name, value)
+	}
+	windows := func(name, help string, values map[string]float64) {
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n# TYPE %s%s gauge\n", prefix, name, help, prefix, name)
+		for _, window := range statusWindows {
+			fmt.Fprintf(&buf, "%s%s{window=\"%s\"} %g\n", prefix, name, window.Name, values[window.Name])
+		}
+	}
+
+	gauge("local_height", "Height of the last scanned block.", status.LocalHeight)
+	gauge("node_height", "Height of the latest block on the node.", status.NodeHeight)
+	gauge("lag_blocks", "Number of blocks the scanner is behind the node.", status.Lag)
+	windows("blocks_per_second", "Blocks scanned per second over the window.", status.BlocksPerSecond)
+	windows("transactions_per_second", "Transactions scanned per second over the window.", status.TxsPerSecond)
+	gauge("unscan_records", "Number of failed records waiting to be rescanned.", status.UnscanRecords)
+	gauge("dead_unscan_records", "Number of failed records moved to dead letter.", status.DeadUnscanRecords)
+	fmt.Fprintf(&buf, "# HELP %serrors_total Number of scan errors.\n# TYPE %serrors_total counter\n%serrors_total %d\n", prefix, prefix, prefix, status.Errors)
+	gauge("last_error_timestamp_seconds", "Unix time of the last scan error.", status.LastErrorTime)
+	gauge("last_block_timestamp_seconds", "Unix time of the last scanned block.", status.LastBlockTime)
+
+	return buf.Bytes()
+}
+
+//startMetricsServer 配置了监听地址时启动Prometheus指标服务
+func (bs *BBCBlockScanner) startMetricsServer() error {
+
+	if len(bs.wm.Config.MetricsListen) == 0 || bs.metricsServer != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", bs.wm.Config.MetricsListen)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(bs.metricsText())
+	})
+
+	bs.metricsServer = &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go bs.metricsServer.Serve(listener)
+
+	bs.wm.Log.Std.Info("block scanner metrics listen on: %s", bs.metricsServer.Addr)
+	return nil
+}
+
+//stopMetricsServer 关闭Prometheus指标服务
+func (bs *BBCBlockScanner) stopMetricsServer() {
+	if bs.metricsServer != nil {
+		bs.metricsServer.Close()
+		bs.metricsServer = nil
+	}
+}
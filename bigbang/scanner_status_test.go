@@ -0,0 +1,94 @@
+package bigbang
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestGetScannerStatus(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return 120, nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+
+	bs := wm.Blockscanner
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+	dai.SaveUnscanRecord(openwallet.NewUnscanRecord(90, "txid_1", "node busy", wm.Symbol()))
+
+	bs.stats.recordBlock(99, 3)
+	bs.stats.recordBlock(100, 5)
+	bs.stats.recordError(errors.New("node busy"))
+
+	status := bs.GetScannerStatus()
+	if status.LocalHeight != 100 || status.NodeHeight != 119 || status.Lag != 19 {
+		t.Fatalf("unexpected heights: local %d, node %d, lag %d", status.LocalHeight, status.NodeHeight, status.Lag)
+	}
+	if status.UnscanRecords != 1 {
+		t.Errorf("unscan records = %d, want 1", status.UnscanRecords)
+	}
+	if status.Errors != 1 || status.LastError != "node busy" {
+		t.Errorf("unexpected errors: %d, %s", status.Errors, status.LastError)
+	}
+	if got := status.BlocksPerSecond["1m"]; got != 2.0/60 {
+		t.Errorf("blocks per second = %v, want %v", got, 2.0/60)
+	}
+	if got := status.TxsPerSecond["15m"]; got != 8.0/900 {
+		t.Errorf("transactions per second = %v, want %v", got, 8.0/900)
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return 11, nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+	wm.Config.MetricsListen = "127.0.0.1:0"
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+	bs.stats.recordBlock(8, 1)
+
+	err := bs.startMetricsServer()
+	if err != nil {
+		t.Fatalf("start metrics server failed: %v", err)
+	}
+	defer bs.stopMetricsServer()
+
+	addr := bs.metricsServer.Addr
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("get metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	prefix := strings.ToLower(wm.Symbol()) + "_scanner_"
+	for _, line := range []string{
+		prefix + "local_height 8",
+		prefix + "node_height 10",
+		prefix + "lag_blocks 2",
+		prefix + "blocks_per_second{window=\"1m\"}",
+		"# TYPE " + prefix + "errors_total counter",
+		prefix + "errors_total 0",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+}
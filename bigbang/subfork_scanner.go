@@ -0,0 +1,182 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"strings"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	subForkBucket = "subfork"
+)
+
+//scanSubForks 扫描已配置的子链
+func (bs *BBCBlockScanner) scanSubForks() {
+	for fork := range bs.wm.Config.SubForks {
+		if !bs.Scanning {
+			return
+		}
+		bs.scanSubFork(fork)
+	}
+}
+
+//scanSubFork 扫描子链的新区块，子链交易提取为子链资产
+func (bs *BBCBlockScanner) scanSubFork(fork string) {
+
+	header, err := bs.GetSubForkScannedBlockHeader(fork)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get sub fork: %s scanned height; unexpected error: %v", fork, err)
+		return
+	}
+
+	currentHeight := header.Height
+	currentHash := header.Hash
+
+	if bs.isReorgHalted(fork) {
+		bs.wm.Log.Std.Info("block scanner sub fork: %s is halted by block reorg on height: %d, please rescan manually", fork, currentHeight)
+		return
+	}
+
+	//子链首次扫描没有本地区块记录，记录起点区块用于分叉时校验共同祖先
+	err = bs.saveForkStartBlock(fork, header)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not save sub fork: %s start block; unexpected error: %v", fork, err)
+		return
+	}
+
+	for {
+
+		if !bs.Scanning {
+			return
+		}
+
+		maxHeight, err := bs.wm.Client.getForkBlockHeight(fork)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not get sub fork: %s block height; unexpected error: %v", fork, err)
+			return
+		}
+
+		bs.setTipHeight(fork, maxHeight)
+
+		if currentHeight >= maxHeight {
+			return
+		}
+
+		block, err := bs.wm.Client.getForkBlockByHeight(currentHeight+1, fork)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not get sub fork: %s block; unexpected error: %v", fork, err)
+			return
+		}
+
+		//子链分叉，回退到共同祖先区块，从新分支继续扫描
+		if currentHash != block.PrevBlockHash {
+			bs.wm.Log.Std.Info("sub fork: %s block has been fork on height: %d.", fork, block.Height)
+			ancestor, err := bs.rollbackForkToCommonAncestor(fork, currentHeight, currentHash)
+			if err != nil {
+				bs.wm.Log.Std.Error("block scanner can not rollback sub fork: %s; unexpected error: %v", fork, err)
+				return
+			}
+			currentHeight = ancestor.Height
+			currentHash = ancestor.Hash
+			continue
+		}
+
+		bs.wm.Log.Std.Info("block scanner scanning sub fork: %s height: %d ...", fork, block.Height)
+
+		block.Fork = fork
+		err = bs.extractBlock(block)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+		}
+
+		currentHeight = block.Height
+		currentHash = block.Hash
+		bs.SaveSubForkScannedBlockHeader(fork, currentHeight, currentHash)
+		bs.saveForkLocalBlock(fork, block)
+	}
+}
+
+//GetSubForkScannedBlockHeader 获取子链已扫高度区块头，没有记录则从子链当前高度的上一个区块开始
+func (bs *BBCBlockScanner) GetSubForkScannedBlockHeader(fork string) (*openwallet.BlockHeader, error) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var header openwallet.BlockHeader
+	err = db.From(subForkBucket).Get(subForkBucket, fork, &header)
+	if err == nil && header.Height > 0 {
+		return &header, nil
+	}
+
+	height, err := bs.wm.Client.getForkBlockHeight(fork)
+	if err != nil {
+		return nil, err
+	}
+
+	if height > 0 {
+		height = height - 1
+	}
+
+	block, err := bs.wm.Client.getForkBlockByHeight(height, fork)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openwallet.BlockHeader{Height: block.Height, Hash: block.Hash, Symbol: bs.wm.Symbol()}, nil
+}
+
+//SaveSubForkScannedBlockHeader 记录子链已扫高度区块头
+func (bs *BBCBlockScanner) SaveSubForkScannedBlockHeader(fork string, height uint64, hash string) error {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	header := &openwallet.BlockHeader{
+		Hash:   hash,
+		Height: height,
+		Symbol: bs.wm.Symbol(),
+	}
+
+	return db.From(subForkBucket).Set(subForkBucket, fork, header)
+}
+
+//forkSymbol 分支在区块链数据访问接口中的记录标识，主链为币种符号，子链为币种符号加子链ID。
+//区块头和未扫记录按分支分开保存，子链的高度不会影响主链的记录。
+func (bs *BBCBlockScanner) forkSymbol(fork string) string {
+	if len(fork) == 0 {
+		return bs.wm.Symbol()
+	}
+	return bs.wm.Symbol() + "_" + fork
+}
+
+//symbolFork 从记录标识解析所属分支，主链为空
+func (bs *BBCBlockScanner) symbolFork(symbol string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(symbol, bs.wm.Symbol()), "_")
+}
+
+//blockFork 区块所属的已配置子链，主链为空
+func (bs *BBCBlockScanner) blockFork(block *Block) string {
+	if _, ok := bs.wm.Config.SubForks[block.Fork]; ok {
+		return block.Fork
+	}
+	return ""
+}
@@ -0,0 +1,236 @@
+package bigbang
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const testSubFork = "00000001b2a9fdc8c4a2c7b0b8d4bb3bdca3aa6b0f0e09e5e9f2e6e1f8a7b3c001"
+
+//testForkChains 模拟节点的主链和子链，chains[fork][i]为分支高度i的区块hash，主链fork为空
+type testForkChains struct {
+	mu     sync.Mutex
+	chains map[string][]string
+	txs    map[string][]string
+}
+
+func (fc *testForkChains) setChain(fork string, hashes ...string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.chains[fork] = hashes
+}
+
+func (fc *testForkChains) setup(node *mockNode) {
+	forkOf := func(params map[string]interface{}) string {
+		fork, _ := params["fork"].(string)
+		return fork
+	}
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		return len(fc.chains[forkOf(params)]), nil
+	})
+	node.handle("getblockhash", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		hashes := fc.chains[forkOf(params)]
+		height := int(params["height"].(float64))
+		if height >= len(hashes) {
+			return nil, &mockRPCError{Code: -6, Message: "Block number out of range."}
+		}
+		return []string{hashes[height]}, nil
+	})
+	node.handle("getblock", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		hash := params["block"].(string)
+		for fork, hashes := range fc.chains {
+			for height, h := range hashes {
+				if h != hash {
+					continue
+				}
+				prev := ""
+				if height > 0 {
+					prev = hashes[height-1]
+				}
+				txs := fc.txs[hash]
+				if txs == nil {
+					txs = []string{}
+				}
+				return map[string]interface{}{"hash": hash, "hashPrev": prev, "height": height, "fork": fork, "tx": txs}, nil
+			}
+		}
+		return nil, &mockRPCError{Code: -6, Message: "Unknown block."}
+	})
+}
+
+//newSubForkTestScanner 创建配置了子链的扫描器，子链上的交易转入1deposit
+func newSubForkTestScanner(t *testing.T, node *mockNode, chains *testForkChains, failed map[string]bool) (*WalletManager, *testBlockchainDAI, *testReorgObserver) {
+	chains.setup(node)
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		txid := params["txid"].(string)
+		if failed[txid] {
+			return nil, &mockRPCError{Code: -6, Message: "Unknown transaction."}
+		}
+		tx := testTransaction(txid, "1sender", "1deposit", 1)
+		tx["transaction"].(map[string]interface{})["anchor"] = testSubFork
+		return tx, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	wm.Config.Anchor = "00000000a137256624bda82aec19645b1dfd9ed6c4c3b86bf4f2e9d8a9b3c071"
+	wm.Config.SubForks[testSubFork] = NewSubForkContract(testSubFork, "SUB")
+
+	dai := newTestBlockchainDAI()
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockScanAddressFunc(func(address string) (string, bool) {
+		return "account", address == "1deposit"
+	})
+	bs.Scanning = true
+
+	observer := &testReorgObserver{orphans: make(map[string][]string)}
+	bs.AddReorgObserver(observer)
+
+	return wm, dai, observer
+}
+
+func TestScanSubFork_KeyedByFork(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	chains := &testForkChains{
+		chains: map[string][]string{
+			"":          {"a0", "a1", "a2", "a3", "a4"},
+			testSubFork: {"s0", "s1", "s2", "s3", "s4"},
+		},
+		txs: map[string][]string{"s4": {"tx_s4"}, "s3": {"tx_s3"}, "x3": {"tx_x3"}},
+	}
+	wm, dai, _ := newSubForkTestScanner(t, node, chains, map[string]bool{"tx_s4": true})
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+	wm.Config.MaxReorgDepth = 2
+
+	bs.SaveSubForkScannedBlockHeader(testSubFork, 2, "s2")
+	bs.scanSubFork(testSubFork)
+
+	//子链的未扫记录和区块头按子链保存
+	records, _ := bs.getAllUnscanRecords()
+	if len(records) != 1 || records[0].TxID != "tx_s4" || records[0].Symbol != bs.forkSymbol(testSubFork) {
+		t.Fatalf("sub fork tx should be recorded as unscan of the sub fork, got: %+v", records)
+	}
+	if main, _ := bs.GetUnscanRecords(); len(main) != 0 {
+		t.Errorf("main chain should have no unscan records, got: %d", len(main))
+	}
+	if block, err := bs.getForkLocalBlock(testSubFork, 4); err != nil || block.Hash != "s4" {
+		t.Errorf("sub fork block should be saved under the sub fork, got: %v, %v", block, err)
+	}
+	if _, err := bs.GetLocalBlock(4); err == nil {
+		t.Errorf("sub fork block should not be saved as main chain block")
+	}
+
+	//主链同高度的孤块不删除子链的未扫记录
+	bs.DeleteUnscanRecord(4)
+	if records, _ := bs.getAllUnscanRecords(); len(records) != 1 {
+		t.Errorf("main chain rollback should not delete sub fork unscan records")
+	}
+
+	//主链高度远超子链时不清理子链的已提取记录
+	bs.saveBlockTxIDs("", 100, "a100", []string{"tx_a100"})
+	if txids, _ := bs.getBlockTxIDs("s3"); len(txids) != 1 {
+		t.Errorf("sub fork extracted txs should not be pruned by main chain height, got: %v", txids)
+	}
+
+	//重扫子链的区块从子链获取
+	dai.SaveUnscanRecord(openwallet.NewUnscanRecord(3, "", "block failed", bs.forkSymbol(testSubFork)))
+	chains.setChain("", "a0", "a1", "a2", "x3", "x4")
+	bs.RescanFailedRecord()
+	for _, r := range dai.unscans {
+		if r.TxID == "" {
+			t.Errorf("sub fork block record should be rescanned from the sub fork, got: %+v", r)
+		}
+	}
+	if txids, _ := bs.getBlockTxIDs("x3"); len(txids) != 0 {
+		t.Errorf("main chain block should not be extracted for sub fork record, got: %v", txids)
+	}
+}
+
+func TestScanSubFork_Reorg(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	chains := &testForkChains{
+		chains: map[string][]string{
+			"":          {"a0", "a1"},
+			testSubFork: {"s0", "s1", "s2", "s3", "s4"},
+		},
+		txs: map[string][]string{"s3": {"tx_s3"}, "s4": {"tx_s4"}},
+	}
+	wm, _, observer := newSubForkTestScanner(t, node, chains, nil)
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	bs.SaveSubForkScannedBlockHeader(testSubFork, 2, "s2")
+	bs.scanSubFork(testSubFork)
+
+	//子链在高度3开始分叉为t3~t5
+	chains.setChain(testSubFork, "s0", "s1", "s2", "t3", "t4", "t5")
+	bs.scanSubFork(testSubFork)
+
+	if len(observer.orphans) != 2 || len(observer.orphans["s3"]) != 1 || len(observer.orphans["s4"]) != 1 {
+		t.Errorf("orphan blocks s3 and s4 should be notified with extracted txids, got: %v", observer.orphans)
+	}
+
+	header, err := bs.GetSubForkScannedBlockHeader(testSubFork)
+	if err != nil || header.Height != 5 || header.Hash != "t5" {
+		t.Errorf("sub fork should rescan the new branch to t5, got: %v, %v", header, err)
+	}
+
+	if len(observer.alerts) != 0 {
+		t.Errorf("reorg within the limit should not alert")
+	}
+}
+
+func TestScanSubFork_InitialStart(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	chains := &testForkChains{
+		chains: map[string][]string{
+			"":          {"a0", "a1"},
+			testSubFork: {"s0", "s1", "s2", "s3", "s4"},
+		},
+		txs: map[string][]string{},
+	}
+	wm, _, observer := newSubForkTestScanner(t, node, chains, nil)
+	defer closeTestWalletManager(wm)
+	bs := wm.Blockscanner
+
+	//没有已扫记录，从子链当前高度的上一个区块开始，并记录起点区块
+	chains.setChain(testSubFork, "s0", "s1", "s2", "s3")
+	bs.scanSubFork(testSubFork)
+	if block, err := bs.getForkLocalBlock(testSubFork, 2); err != nil || block.Hash != "s2" {
+		t.Fatalf("sub fork start block should be saved, got: %v, %v", block, err)
+	}
+
+	chains.setChain(testSubFork, "s0", "s1", "s2", "s3", "s4")
+	bs.scanSubFork(testSubFork)
+	header, _ := bs.GetSubForkScannedBlockHeader(testSubFork)
+	if header.Height != 4 || header.Hash != "s4" {
+		t.Fatalf("sub fork should scan from the start block to s4, got: %v", header)
+	}
+
+	//分叉越过起点区块时无法校验共同祖先，停止扫描并只告警一次
+	chains.setChain(testSubFork, "s0", "t1", "t2", "t3", "t4", "t5")
+	bs.scanSubFork(testSubFork)
+	bs.scanSubFork(testSubFork)
+	if len(observer.alerts) != 1 || len(observer.orphans) != 0 {
+		t.Errorf("reorg below the start block should alert once without rollback, got alerts: %v, orphans: %v", observer.alerts, observer.orphans)
+	}
+	header, _ = bs.GetSubForkScannedBlockHeader(testSubFork)
+	if header.Height != 4 || header.Hash != "s4" {
+		t.Errorf("sub fork should stay on the local head, got: %v", header)
+	}
+}
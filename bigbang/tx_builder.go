@@ -0,0 +1,192 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/blocktree/go-owcdrivers/bigbangTransaction"
+	owcrypt "github.com/blocktree/go-owcrypt"
+)
+
+//交易类型
+const (
+	TxTypeToken = uint16(0x0000) //普通转账
+)
+
+const (
+	addressAlphabet       = "0123456789abcdefghjkmnpqrstvwxyz"
+	addressPrefixPubkey   = '1' //公钥地址
+	addressPrefixTemplate = '2' //模板地址
+)
+
+//isTemplateAddress 是否模板地址
+func isTemplateAddress(address string) bool {
+	return len(address) > 0 && address[0] == addressPrefixTemplate
+}
+
+//decodeDestination 解析公钥地址或模板地址为交易目标，bigbangTransaction只支持公钥地址
+func decodeDestination(address string) ([]byte, error) {
+	if len(address) == 0 || (address[0] != addressPrefixPubkey && address[0] != addressPrefixTemplate) {
+		return nil, errors.New("Invalid address, only public key or template address supported!")
+	}
+
+	data, err := base32.NewEncoding(addressAlphabet).DecodeString(address[1:])
+	if err != nil || len(data) != 32+3 {
+		return nil, errors.New("Invalid address!")
+	}
+
+	chksum := [4]byte{}
+	binary.BigEndian.PutUint32(chksum[:], crc24q(data[:32]))
+	for i := 0; i < 3; i++ {
+		if chksum[i+1] != data[32+i] {
+			return nil, errors.New("Invalid address with bad checksum!")
+		}
+	}
+
+	return append([]byte{address[0] - '0'}, data[:32]...), nil
+}
+
+//compactSize 变长长度编码
+func compactSize(n uint64) []byte {
+	switch {
+	case n < 0xfd:
+		return []byte{byte(n)}
+	case n <= 0xffff:
+		buf := make([]byte, 3)
+		buf[0] = 0xfd
+		binary.LittleEndian.PutUint16(buf[1:], uint16(n))
+		return buf
+	case n <= 0xffffffff:
+		buf := make([]byte, 5)
+		buf[0] = 0xfe
+		binary.LittleEndian.PutUint32(buf[1:], uint32(n))
+		return buf
+	default:
+		buf := make([]byte, 9)
+		buf[0] = 0xff
+		binary.LittleEndian.PutUint64(buf[1:], n)
+		return buf
+	}
+}
+
+//reverseHex 解析hex并反转字节序
+func reverseHex(hexStr string) ([]byte, error) {
+	data, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, err
+	}
+	return inverseBytes(data), nil
+}
+
+//createTemplateTransactionAndHash 构造未签名交易单及其哈希，支持指定交易类型和模板地址
+func createTemplateTransactionAndHash(txType uint16, lockUntil uint32, anchor string, inputs []bigbangTransaction.Vin, to string, amount, fee uint64, data []byte) (string, string, error) {
+
+	anchorBytes, err := reverseHex(anchor)
+	if err != nil || len(anchorBytes) != 32 {
+		return "", "", errors.New("Invalid anchor string!")
+	}
+
+	if len(inputs) == 0 {
+		return "", "", errors.New("Miss input!")
+	}
+
+	toBytes, err := decodeDestination(to)
+	if err != nil {
+		return "", "", err
+	}
+
+	if amount == 0 {
+		return "", "", errors.New("Invalid amount!")
+	}
+
+	if fee == 0 {
+		return "", "", errors.New("Invalid fee!")
+	}
+
+	buf := make([]byte, 8)
+	tx := make([]byte, 0)
+
+	binary.LittleEndian.PutUint16(buf, bigbangTransaction.DefaultVersion)
+	tx = append(tx, buf[:2]...)
+	binary.LittleEndian.PutUint16(buf, txType)
+	tx = append(tx, buf[:2]...)
+	binary.LittleEndian.PutUint32(buf, uint32(time.Now().Unix()))
+	tx = append(tx, buf[:4]...)
+	binary.LittleEndian.PutUint32(buf, lockUntil)
+	tx = append(tx, buf[:4]...)
+	tx = append(tx, anchorBytes...)
+
+	tx = append(tx, compactSize(uint64(len(inputs)))...)
+	for _, in := range inputs {
+		txid, err := reverseHex(in.TxID)
+		if err != nil || len(txid) != 32 {
+			return "", "", errors.New("Invalid txid!")
+		}
+		tx = append(tx, txid...)
+		tx = append(tx, in.Vout)
+	}
+
+	tx = append(tx, toBytes...)
+	binary.LittleEndian.PutUint64(buf, amount)
+	tx = append(tx, buf...)
+	binary.LittleEndian.PutUint64(buf, fee)
+	tx = append(tx, buf...)
+	tx = append(tx, compactSize(uint64(len(data)))...)
+	tx = append(tx, data...)
+
+	hash := owcrypt.Hash(tx, 32, owcrypt.HASH_ALG_BLAKE2B)
+
+	return hex.EncodeToString(tx), hex.EncodeToString(hash), nil
+}
+
+//combineTemplateTransaction 验证签名并合并交易单，花费模板地址时签名前需附加模板数据
+func combineTemplateTransaction(emptyTrans, templateData, signature string, pubkey []byte) (bool, string) {
+
+	trans, err := hex.DecodeString(emptyTrans)
+	if err != nil || len(trans) == 0 {
+		return false, ""
+	}
+
+	tplData, err := hex.DecodeString(templateData)
+	if err != nil {
+		return false, ""
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != 64 {
+		return false, ""
+	}
+
+	if len(pubkey) != 32 {
+		return false, ""
+	}
+
+	hash := owcrypt.Hash(trans, 32, owcrypt.HASH_ALG_BLAKE2B)
+	if owcrypt.Verify(pubkey, nil, hash, sig, owcrypt.ECC_CURVE_ED25519) != owcrypt.SUCCESS {
+		return false, ""
+	}
+
+	vchSig := append(tplData, sig...)
+	trans = append(trans, compactSize(uint64(len(vchSig)))...)
+	trans = append(trans, vchSig...)
+
+	return true, hex.EncodeToString(trans)
+}
@@ -0,0 +1,63 @@
+package bigbang
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/bigbangTransaction"
+)
+
+//testEncodeAddress 按地址规则编码公钥或模板ID
+func testEncodeAddress(prefix byte, data []byte) string {
+	chksum := [4]byte{}
+	binary.BigEndian.PutUint32(chksum[:], crc24q(data))
+	return string(prefix) + base32.NewEncoding(addressAlphabet).EncodeToString(append(data, chksum[1:]...))
+}
+
+func TestDecodeDestination(t *testing.T) {
+	data := bytes.Repeat([]byte{0x5a}, 32)
+
+	for _, prefix := range []byte{addressPrefixPubkey, addressPrefixTemplate} {
+		dest, err := decodeDestination(testEncodeAddress(prefix, data))
+		if err != nil {
+			t.Fatalf("decodeDestination failed, unexpected error: %v", err)
+		}
+		if dest[0] != prefix-'0' || !bytes.Equal(dest[1:], data) {
+			t.Errorf("decodeDestination got wrong destination: %x", dest)
+		}
+	}
+
+	bad := []byte(testEncodeAddress(addressPrefixTemplate, data))
+	bad[5] = '0'
+	if _, err := decodeDestination(string(bad)); err == nil {
+		t.Errorf("decodeDestination should fail with bad checksum")
+	}
+}
+
+func TestCreateTemplateTransactionAndHash(t *testing.T) {
+	anchor := strings.Repeat("ab", 32)
+	vins := []bigbangTransaction.Vin{{TxID: strings.Repeat("01", 32), Vout: 1}}
+	to := testEncodeAddress(addressPrefixPubkey, bytes.Repeat([]byte{0x11}, 32))
+
+	expect, _, err := bigbangTransaction.CreateEmptyTransactionAndHash(0, anchor, vins, to, 1000, 100, "memo")
+	if err != nil {
+		t.Fatalf("CreateEmptyTransactionAndHash failed, unexpected error: %v", err)
+	}
+
+	got, _, err := createTemplateTransactionAndHash(TxTypeToken, 0, anchor, vins, to, 1000, 100, []byte("memo"))
+	if err != nil {
+		t.Fatalf("createTemplateTransactionAndHash failed, unexpected error: %v", err)
+	}
+
+	expectBytes, _ := hex.DecodeString(expect)
+	gotBytes, _ := hex.DecodeString(got)
+
+	//除时间戳外与bigbangTransaction构造的交易单一致
+	if len(expectBytes) != len(gotBytes) || !bytes.Equal(expectBytes[:4], gotBytes[:4]) || !bytes.Equal(expectBytes[8:], gotBytes[8:]) {
+		t.Errorf("transaction mismatch:\nexpect: %s\ngot:    %s", expect, got)
+	}
+}
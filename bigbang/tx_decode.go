@@ -21,6 +21,7 @@ import (
 	"github.com/blocktree/go-owcdrivers/bigbangTransaction"
 	"math/big"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/blocktree/openwallet/log"
@@ -42,10 +43,14 @@ func NewTransactionDecoder(wm *WalletManager) *TransactionDecoder {
 //CreateRawTransaction 创建交易单
 func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
-	if rawTx.Coin.IsContract {
+	if rawTx.Coin.IsContract && len(rawTx.Coin.Contract.Address) == 0 {
 		return openwallet.Errorf(openwallet.ErrContractNotFound, "[%s] have not contract", rawTx.Account.AccountID)
 	}
 
+	if rawTx.GetExtParam().Get("dpos").String() == DPoSActionRevoke {
+		return decoder.CreateRevokeRawTransaction(wrapper, rawTx)
+	}
+
 	return decoder.CreateBBCRawTransaction(wrapper, rawTx)
 }
 
@@ -68,14 +73,89 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		return nil, fmt.Errorf("transaction is not completed validation")
 	}
 
+	//客户端提供的幂等请求ID，重试时返回已广播的结果
+	requestID := rawTx.GetExtParam().Get("requestID").String()
+	if len(requestID) > 0 {
+		//按来源地址加锁，交易单没有来源地址时按请求ID
+		lockKey := strings.Join(rawTx.TxFrom, ",")
+		if len(lockKey) == 0 {
+			lockKey = "request:" + requestID
+		}
+		unlock := decoder.wm.TxOutbox.lockSubmit(lockKey)
+		defer unlock()
+
+		record, err := decoder.wm.TxOutbox.GetSubmitRequest(requestID)
+		if err == nil {
+			if len(record.TxID) > 0 {
+				decoder.wm.Log.Std.Info("request: %s has been submitted, txid: %s", requestID, record.TxID)
+				rawTx.TxID = record.TxID
+				rawTx.RawHex = record.RawHex
+				rawTx.IsSubmit = true
+				return decoder.newSubmittedTransaction(rawTx, record.CreateAt), nil
+			}
+			//上次广播结果未知，重新广播原交易，避免广播第二笔交易
+			rawTx.RawHex = record.RawHex
+		} else {
+			//广播前先记录，保证重试时使用同一笔交易
+			err = decoder.wm.TxOutbox.SaveSubmitRequest(&SubmitRequest{
+				RequestID: requestID,
+				RawHex:    rawTx.RawHex,
+				AccountID: rawTx.Account.AccountID,
+				CreateAt:  time.Now().Unix(),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	txid, err := decoder.wm.SendRawTransaction(rawTx.RawHex)
 	if err != nil {
-		fmt.Println("Tx to send: ", rawTx.RawHex)
-		return nil, err
+		if !isAlreadyInPoolError(err) {
+			decoder.wm.Log.Std.Error("submit transaction failed, raw hex: %s; unexpected error: %v", rawTx.RawHex, err)
+			return nil, err
+		}
+
+		//节点已有该交易，视为广播成功
+		txid, err = decoder.wm.Client.decodeTransactionID(rawTx.RawHex)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
+	submitTime := time.Now().Unix()
+
+	if len(requestID) > 0 {
+		err = decoder.wm.TxOutbox.SaveSubmitRequest(&SubmitRequest{
+			RequestID: requestID,
+			TxID:      txid,
+			RawHex:    rawTx.RawHex,
+			AccountID: rawTx.Account.AccountID,
+			CreateAt:  submitTime,
+		})
+		if err != nil {
+			decoder.wm.Log.Std.Error("request: %s can not save submitted transaction: %s; unexpected error: %v", requestID, txid, err)
+		}
+	}
+
+	err = decoder.wm.saveSubmittedVoteTemplate(rawTx)
+	if err != nil {
+		decoder.wm.Log.Std.Error("can not save vote template of transaction: %s; unexpected error: %v", txid, err)
+	}
+
+	//记录到发件箱，跟踪交易直至确认
+	err = decoder.wm.TxOutbox.Add(txid, rawTx.RawHex, rawTx.Account.AccountID)
+	if err != nil {
+		decoder.wm.Log.Std.Error("tx outbox can not save transaction: %s; unexpected error: %v", txid, err)
+	}
+
+	return decoder.newSubmittedTransaction(rawTx, submitTime), nil
+}
+
+//newSubmittedTransaction 根据已广播的交易单生成交易记录
+func (decoder *TransactionDecoder) newSubmittedTransaction(rawTx *openwallet.RawTransaction, submitTime int64) *openwallet.Transaction {
 
 	tx := openwallet.Transaction{
 		From:       rawTx.TxFrom,
@@ -86,12 +166,12 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		Decimal:    decoder.wm.Decimal(),
 		AccountID:  rawTx.Account.AccountID,
 		Fees:       rawTx.Fees,
-		SubmitTime: time.Now().Unix(),
+		SubmitTime: submitTime,
 	}
 
 	tx.WxID = openwallet.GenTransactionWxID(&tx)
 
-	return &tx, nil
+	return &tx
 }
 
 func (decoder *TransactionDecoder) CreateBBCRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
@@ -108,31 +188,37 @@ func (decoder *TransactionDecoder) CreateBBCRawTransaction(wrapper openwallet.Wa
 
 	addressesBalanceList := make([]AddrBalance, 0, len(addresses))
 
-	anchor, err := decoder.wm.Client.getAnchor()
+	//子链资产使用子链的anchor
+	anchor, fork, err := decoder.wm.getCoinAnchor(rawTx.Coin)
 	if err != nil {
 		return openwallet.Errorf(openwallet.ErrUnknownException, "Fail to get anchor!")
 	}
-	for i, addr := range addresses {
-		balance, err := decoder.wm.Client.getBalance(addr.Address, anchor)
+	searchAddrs := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		searchAddrs = append(searchAddrs, addr.Address)
+	}
 
-		if err != nil {
-			return err
-		}
+	balances, err := decoder.wm.getAddressBalances(searchAddrs, anchor)
+	if err != nil {
+		return err
+	}
 
-		balance.index = i
-		addressesBalanceList = append(addressesBalanceList, *balance)
+	//余额可能来自缓存，复制后再排序
+	for i, balance := range balances {
+		addrBalance := *balance
+		addrBalance.index = i
+		addressesBalanceList = append(addressesBalanceList, addrBalance)
 	}
 
 	sort.Slice(addressesBalanceList, func(i int, j int) bool {
 		return addressesBalanceList[i].Balance.Cmp(addressesBalanceList[j].Balance) >= 0
 	})
 
-	var fee uint64
-	if len(rawTx.FeeRate) != 0 {
-		fee = convertFromAmount(rawTx.FeeRate)
-	} else {
-		fee = decoder.wm.Config.FixedFee
+	memo, err := encodeMemo(rawTx.GetExtParam().Get("memo").String(), rawTx.GetExtParam().Get("memoType").String())
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid memo: %v", err)
 	}
+	fee := decoder.wm.getTransactionFee(rawTx.FeeRate, memo)
 
 	var amountStr, to string
 	for k, v := range rawTx.To {
@@ -164,7 +250,7 @@ func (decoder *TransactionDecoder) CreateBBCRawTransaction(wrapper openwallet.Wa
 		}
 	}
 
-	utxosInPool, err := decoder.wm.Client.getUTXOsInPool()
+	utxosInPool, err := decoder.wm.Client.getForkUTXOsInPool(fork)
 	if err != nil {
 		openwallet.Errorf(openwallet.ErrUnknownException, "Failed to get transactions in pool%s!", "")
 	}
@@ -174,7 +260,7 @@ func (decoder *TransactionDecoder) CreateBBCRawTransaction(wrapper openwallet.Wa
 
 	for _, enoughBalance := range enoughBalanceList {
 		balanceSum := big.NewInt(0)
-		utxos, err := decoder.wm.Client.listUnnSpent(enoughBalance.Address, anchor)
+		utxos, err := decoder.wm.listUnspent(enoughBalance.Address, anchor)
 		if err != nil {
 			openwallet.Errorf(openwallet.ErrUnknownException, "Failed to get utxo of address : [%s]!", enoughBalance.Address)
 		}
@@ -207,6 +293,17 @@ func (decoder *TransactionDecoder) CreateBBCRawTransaction(wrapper openwallet.Wa
 		openwallet.Errorf(openwallet.ErrUnknownException, "Please wait until the transactions in pool being confirmed[%s]!", "")
 	}
 
+	//投票交易发送到投票模板地址，委托人为to，投票人为from，广播成功后记录投票模板地址
+	if rawTx.GetExtParam().Get("dpos").String() == DPoSActionVote {
+		delegate := to
+		to, err = decoder.wm.createVoteTemplate(delegate, from)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "Failed to create vote template: %v", err)
+		}
+		rawTx.SetExtParam("voteAddress", to)
+		rawTx.SetExtParam("voteDelegate", delegate)
+	}
+
 	rawTx.TxFrom = []string{from}
 	rawTx.TxTo = []string{to}
 	rawTx.TxAmount = amountStr
@@ -215,9 +312,13 @@ func (decoder *TransactionDecoder) CreateBBCRawTransaction(wrapper openwallet.Wa
 
 	lockUntil := uint32(0)
 	sendamount := convertFromAmount(amountStr)
-	memo := rawTx.GetExtParam().Get("memo").String()
 
-	emptyTrans, hash, err := bigbangTransaction.CreateEmptyTransactionAndHash(lockUntil, anchor,vins, to, sendamount, fee, memo)
+	var emptyTrans, hash string
+	if isTemplateAddress(to) {
+		emptyTrans, hash, err = createTemplateTransactionAndHash(TxTypeToken, lockUntil, anchor, vins, to, sendamount, fee, memo)
+	} else {
+		emptyTrans, hash, err = bigbangTransaction.CreateEmptyTransactionAndHash(lockUntil, anchor,vins, to, sendamount, fee, string(memo))
+	}
 	if err != nil {
 		return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
 	}
@@ -317,7 +418,19 @@ func (decoder *TransactionDecoder) VerifyBBCRawTransaction(wrapper openwallet.Wa
 	}
 
 	pubBytes, _ := hex.DecodeString(pubkey)
-	pass, signedTrans := bigbangTransaction.VerifyAndCombineTransaction(emptyTrans, signature, pubBytes)
+
+	var (
+		pass        bool
+		signedTrans string
+	)
+
+	//花费模板地址的交易，签名需附加模板数据
+	templateData := rawTx.GetExtParam().Get("templateData").String()
+	if len(templateData) > 0 {
+		pass, signedTrans = combineTemplateTransaction(emptyTrans, templateData, signature, pubBytes)
+	} else {
+		pass, signedTrans = bigbangTransaction.VerifyAndCombineTransaction(emptyTrans, signature, pubBytes)
+	}
 
 	if pass {
 		log.Debug("transaction verify passed")
@@ -332,22 +445,153 @@ func (decoder *TransactionDecoder) VerifyBBCRawTransaction(wrapper openwallet.Wa
 }
 
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
-	return convertToAmount(decoder.wm.Config.FixedFee), "TX", nil
+	if decoder.wm.Config.FixedFee > 0 {
+		return convertToAmount(decoder.wm.Config.FixedFee), "TX", nil
+	}
+	return convertToAmount(decoder.wm.EstimateFee(nil)), "TX", nil
 }
 
 //CreateSummaryRawTransaction 创建汇总交易，返回原始交易单数组
+//ExtParam中设置 "preview": true 时，只返回汇总预览，不生成RawHex
 func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
 	if sumRawTx.Coin.IsContract {
 		return nil, openwallet.Errorf(openwallet.ErrContractNotFound, "[%s] have not contract", sumRawTx.Account.AccountID)
-	} else {
-		return decoder.CreateSimpleSummaryRawTransaction(wrapper, sumRawTx)
 	}
+
+	if sumRawTx.GetExtParam().Get("preview").Bool() {
+		return decoder.CreatePreviewSummaryRawTransaction(wrapper, sumRawTx)
+	}
+
+	return decoder.CreateSimpleSummaryRawTransaction(wrapper, sumRawTx)
+}
+
+//SummaryPreview 地址汇总预览
+type SummaryPreview struct {
+	Address         string    `json:"address"`
+	Balance         string    `json:"balance"`
+	UsableUTXOs     []UnSpent `json:"usableUTXOs"`
+	SkippedUTXOs    []UnSpent `json:"skippedUTXOs"` //已在交易池中被花费的UTXO
+	Fee             string    `json:"fee"`
+	RetainedBalance string    `json:"retainedBalance"`
+	SummaryAmount   string    `json:"summaryAmount"`
+	Summary         bool      `json:"summary"` //是否会被汇总
+	Reason          string    `json:"reason"`  //不汇总或失败的原因
+
+	addrBalance *openwallet.Balance
+	vins        []bigbangTransaction.Vin
+	fee         uint64
+	err         error
+}
+
+//PreviewSummaryRawTransaction 预览汇总交易，返回每个地址的汇总计划
+func (decoder *TransactionDecoder) PreviewSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*SummaryPreview, error) {
+	plans, err := decoder.summaryPlans(wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, plan := range plans {
+		if plan.err != nil {
+			plan.Summary = false
+			plan.Reason = plan.err.Error()
+		}
+	}
+
+	return plans, nil
+}
+
+//CreatePreviewSummaryRawTransaction 创建汇总预览交易单，每个地址一个，交易单不包含RawHex，预览结果保存在ExtParam的preview
+func (decoder *TransactionDecoder) CreatePreviewSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
+
+	rawTxArray := make([]*openwallet.RawTransaction, 0)
+
+	plans, err := decoder.PreviewSummaryRawTransaction(wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	//不汇总的地址也返回，原因在预览的reason
+	for _, plan := range plans {
+		rawTx := &openwallet.RawTransaction{
+			Coin:     sumRawTx.Coin,
+			Account:  sumRawTx.Account,
+			To:       map[string]string{},
+			Required: 1,
+			TxFrom:   []string{plan.Address},
+			TxTo:     []string{},
+			TxAmount: plan.SummaryAmount,
+			Fees:     plan.Fee,
+			FeeRate:  plan.Fee,
+		}
+		if plan.Summary {
+			rawTx.To[sumRawTx.SummaryAddress] = plan.SummaryAmount
+			rawTx.TxTo = []string{sumRawTx.SummaryAddress}
+		}
+		rawTx.SetExtParam("preview", plan)
+
+		rawTxArray = append(rawTxArray, rawTx)
+	}
+
+	return rawTxArray, nil
 }
 
 func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
 
 	var (
-		rawTxArray      = make([]*openwallet.RawTransaction, 0)
+		rawTxArray = make([]*openwallet.RawTransaction, 0)
+	)
+
+	plans, err := decoder.summaryPlans(wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, plan := range plans {
+
+		if plan.err != nil {
+			return nil, plan.err
+		}
+
+		if !plan.Summary {
+			continue
+		}
+
+		log.Debugf("balance: %v", plan.Balance)
+		log.Debugf("fees: %v", plan.Fee)
+		log.Debugf("sumAmount: %v", plan.SummaryAmount)
+
+		//创建一笔交易单
+		rawTx := &openwallet.RawTransaction{
+			Coin:    sumRawTx.Coin,
+			Account: sumRawTx.Account,
+			To: map[string]string{
+				sumRawTx.SummaryAddress: plan.SummaryAmount,
+			},
+			Required: 1,
+		}
+
+		createErr := decoder.createRawTransaction(
+			wrapper,
+			rawTx,
+			plan.addrBalance,
+			plan.fee,
+			plan.vins)
+		if createErr != nil {
+			return nil, createErr
+		}
+
+		//创建成功，添加到队列
+		rawTxArray = append(rawTxArray, rawTx)
+
+	}
+	return rawTxArray, nil
+}
+
+//summaryPlans 计算账户下每个地址的汇总计划，预览和实际汇总使用同一套选择逻辑
+func (decoder *TransactionDecoder) summaryPlans(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*SummaryPreview, error) {
+
+	var (
+		plans           = make([]*SummaryPreview, 0)
 		accountID       = sumRawTx.Account.AccountID
 		minTransfer     = big.NewInt(int64(convertFromAmount(sumRawTx.MinTransfer)))
 		retainedBalance = big.NewInt(int64(convertFromAmount(sumRawTx.RetainedBalance)))
@@ -378,29 +622,39 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 		return nil, err
 	}
 
-	feeInt := uint64(0)
-	if len(sumRawTx.FeeRate) != 0 {
-		feeInt = convertFromAmount(sumRawTx.FeeRate)
-	} else {
-		feeInt = decoder.wm.Config.FixedFee
-	}
+	//汇总交易不带memo
+	feeInt := decoder.wm.getTransactionFee(sumRawTx.FeeRate, nil)
 
-	anchor, err := decoder.wm.Client.getAnchor()
+	anchor, err := decoder.wm.getAnchor()
 	if err != nil {
 		return nil, openwallet.Errorf(openwallet.ErrUnknownException, "Fail to get anchor!")
 	}
 
 	for _, addrBalance := range addrBalanceArray {
 
-		//检查余额是否超过最低转账
-		addrBalance_BI := big.NewInt(int64(convertFromAmount(addrBalance.Balance)))
+		plan := &SummaryPreview{
+			Address:         addrBalance.Address,
+			Balance:         addrBalance.ConfirmBalance,
+			UsableUTXOs:     make([]UnSpent, 0),
+			SkippedUTXOs:    make([]UnSpent, 0),
+			Fee:             convertToAmount(feeInt),
+			RetainedBalance: convertToAmount(retainedBalance.Uint64()),
+			SummaryAmount:   "0",
+			addrBalance:     addrBalance,
+			fee:             feeInt,
+		}
+		plans = append(plans, plan)
+
+		//检查可花费余额是否超过最低转账，锁定和未确认的余额不汇总
+		addrBalance_BI := big.NewInt(int64(convertFromAmount(addrBalance.ConfirmBalance)))
 
 		if addrBalance_BI.Cmp(big.NewInt(0)) == 0 || addrBalance_BI.Cmp(minTransfer) < 0 {
+			plan.Reason = "balance is less than min transfer"
 			continue
 		}
 
 		// 获取地址的UTXO
-		utxos, err := decoder.wm.Client.listUnnSpent(addrBalance.Address, anchor)
+		utxos, err := decoder.wm.listUnspent(addrBalance.Address, anchor)
 		if err != nil {
 			return nil, openwallet.Errorf(openwallet.ErrUnknownException, "Failed to get unspent record of address [%s]!", addrBalance.Address)
 		}
@@ -411,71 +665,38 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 			return nil, openwallet.Errorf(openwallet.ErrUnknownException, "Failed to get transactions in tx pool [%s]!", "")
 		}
 
-		vins := []bigbangTransaction.Vin{}
-		sumAmount_BI := new(big.Int)
 		for _, utxo := range utxos {
 			if isUnspentAlreadyInPool(utxosInPool, utxo) {
+				plan.SkippedUTXOs = append(plan.SkippedUTXOs, utxo)
 				continue
 			}
 
-			vins = append(vins, bigbangTransaction.Vin{
+			plan.UsableUTXOs = append(plan.UsableUTXOs, utxo)
+			plan.vins = append(plan.vins, bigbangTransaction.Vin{
 				TxID: utxo.TxID,
 				Vout: utxo.Vout,
 			})
-
-			sumAmount_BI.Add(sumAmount_BI, big.NewInt(int64(utxo.Amount)))
 		}
 
-		if len(vins) == 0 {
-			return nil, openwallet.Errorf(openwallet.ErrUnknownException, "Address [%s] has unconfirmed transaction, Try summary again later!", addrBalance.Address)
+		if len(plan.vins) == 0 {
+			plan.err = openwallet.Errorf(openwallet.ErrUnknownException, "Address [%s] has unconfirmed transaction, Try summary again later!", addrBalance.Address)
+			continue
 		}
 
-		//计算汇总数量 = 余额 - 保留余额
+		//计算汇总数量 = 余额 - 保留余额 - 手续费
+		sumAmount_BI := new(big.Int)
 		sumAmount_BI.Sub(addrBalance_BI, retainedBalance)
-
-		//this.wm.Log.Debug("sumAmount:", sumAmount)
-		//计算手续费
-
-		fee := big.NewInt(int64(feeInt))
-
-		//减去手续费
-		sumAmount_BI.Sub(sumAmount_BI, fee)
+		sumAmount_BI.Sub(sumAmount_BI, big.NewInt(int64(feeInt)))
 		if sumAmount_BI.Cmp(big.NewInt(0)) <= 0 {
+			plan.Reason = "balance is not enough to pay retained balance and fee"
 			continue
 		}
 
-		sumAmount := convertToAmount(sumAmount_BI.Uint64())
-		fees := convertToAmount(fee.Uint64())
-
-		log.Debugf("balance: %v", addrBalance.Balance)
-		log.Debugf("fees: %v", fees)
-		log.Debugf("sumAmount: %v", sumAmount)
-
-		//创建一笔交易单
-		rawTx := &openwallet.RawTransaction{
-			Coin:    sumRawTx.Coin,
-			Account: sumRawTx.Account,
-			To: map[string]string{
-				sumRawTx.SummaryAddress: sumAmount,
-			},
-			Required: 1,
-		}
-
-		createErr := decoder.createRawTransaction(
-			wrapper,
-			rawTx,
-			addrBalance,
-			feeInt,
-			vins)
-		if createErr != nil {
-			return nil, createErr
-		}
-
-		//创建成功，添加到队列
-		rawTxArray = append(rawTxArray, rawTx)
-
+		plan.SummaryAmount = convertToAmount(sumAmount_BI.Uint64())
+		plan.Summary = true
 	}
-	return rawTxArray, nil
+
+	return plans, nil
 }
 
 func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, addrBalance *openwallet.Balance, fee uint64, vins []bigbangTransaction.Vin) error {
@@ -502,7 +723,7 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 	rawTx.FeeRate = convertToAmount(fee)
 
 	lockUntil := uint32(0)
-	anchor, err := decoder.wm.Client.getAnchor()
+	anchor, err := decoder.wm.getAnchor()
 	if err != nil {
 		return openwallet.Errorf(openwallet.ErrUnknownException, "Fail to get anchor!")
 	}
@@ -0,0 +1,101 @@
+package bigbang
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//testWalletDAI 只提供地址列表的钱包数据访问接口
+type testWalletDAI struct {
+	openwallet.WalletDAIBase
+	addresses []*openwallet.Address
+}
+
+func (dai *testWalletDAI) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	return dai.addresses, nil
+}
+
+func (dai *testWalletDAI) GetAddress(address string) (*openwallet.Address, error) {
+	for _, addr := range dai.addresses {
+		if addr.Address == address {
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("address: %s is not found", address)
+}
+
+func TestCreateSummaryRawTransaction_Preview(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	balances := map[string]string{"1rich": "10", "1poor": "0.001", "1pooled": "5"}
+	unspents := map[string][]interface{}{
+		"1rich":   {map[string]interface{}{"txid": "txid_rich", "out": 0, "amount": "10"}},
+		"1poor":   {map[string]interface{}{"txid": "txid_poor", "out": 0, "amount": "0.001"}},
+		"1pooled": {map[string]interface{}{"txid": "txid_pooled", "out": 0, "amount": "5"}},
+	}
+	node.handle("getbalance", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return []interface{}{map[string]interface{}{"avail": balances[params["address"].(string)]}}, nil
+	})
+	node.handle("listunspent", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"unspents": unspents[params["address"].(string)]}, nil
+	})
+	node.handle("gettxpool", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"list": []interface{}{map[string]interface{}{"hex": "txid_pool"}}}, nil
+	})
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testVinTransaction("txid_pool", "1pooled", "1user", "1", "txid_pooled", "0"), nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+
+	wrapper := &testWalletDAI{}
+	for _, addr := range []string{"1rich", "1poor", "1pooled"} {
+		wrapper.addresses = append(wrapper.addresses, &openwallet.Address{Address: addr, AccountID: "account"})
+	}
+
+	sumRawTx := &openwallet.SummaryRawTransaction{
+		Coin:            openwallet.Coin{Symbol: wm.Symbol()},
+		Account:         &openwallet.AssetsAccount{AccountID: "account"},
+		SummaryAddress:  "1summary",
+		MinTransfer:     "0.1",
+		RetainedBalance: "0",
+		FeeRate:         "0.01",
+		AddressLimit:    -1,
+	}
+	sumRawTx.SetExtParam("preview", true)
+
+	rawTxs, err := wm.TxDecoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
+	if err != nil {
+		t.Fatalf("CreateSummaryRawTransaction preview failed: %v", err)
+	}
+
+	//每个地址都返回预览，不汇总的带原因
+	if len(rawTxs) != 3 {
+		t.Fatalf("preview should list every address, got: %d", len(rawTxs))
+	}
+
+	for _, rawTx := range rawTxs {
+		if len(rawTx.RawHex) > 0 {
+			t.Errorf("preview should not build raw hex for %v", rawTx.TxFrom)
+		}
+		preview := rawTx.GetExtParam().Get("preview")
+		switch rawTx.TxFrom[0] {
+		case "1rich":
+			if !preview.Get("summary").Bool() || rawTx.To["1summary"] != "9.99" {
+				t.Errorf("1rich should be summarized 9.99, got: %s, to: %v", preview.Raw, rawTx.To)
+			}
+		case "1poor":
+			if preview.Get("summary").Bool() || preview.Get("reason").String() != "balance is less than min transfer" || len(rawTx.To) != 0 {
+				t.Errorf("1poor should be skipped with reason, got: %s", preview.Raw)
+			}
+		case "1pooled":
+			if preview.Get("summary").Bool() || len(preview.Get("reason").String()) == 0 || len(preview.Get("skippedUTXOs").Array()) != 1 {
+				t.Errorf("1pooled should be skipped with pooled utxos, got: %s", preview.Raw)
+			}
+		}
+	}
+}
@@ -0,0 +1,374 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+const (
+	outboxBucket        = "outbox"
+	submitRequestBucket = "submit_request"
+)
+
+//已广播交易的状态
+const (
+	OutboxStatusPending    = "pending"    //等待确认
+	OutboxStatusMissing    = "missing"    //已离开交易池，等待重播
+	OutboxStatusConfirmed  = "confirmed"  //已确认
+	OutboxStatusDropped    = "dropped"    //多次重播后仍未确认，已放弃
+	OutboxStatusConflicted = "conflicted" //输入已被其他交易花费
+)
+
+//OutboxTx 已广播的交易记录
+type OutboxTx struct {
+	TxID         string `storm:"id"`
+	RawHex       string
+	AccountID    string
+	Status       string `storm:"index"`
+	Reason       string
+	Rebroadcasts int
+	SubmitTime   int64
+	UpdateTime   int64
+}
+
+//SubmitRequest 客户端提交的幂等请求，记录请求ID对应的已广播交易
+type SubmitRequest struct {
+	RequestID string `storm:"id"`
+	TxID      string
+	RawHex    string
+	AccountID string
+	CreateAt  int64
+}
+
+//TxStatusObserver 交易状态变化的观察者
+type TxStatusObserver interface {
+
+	//TxStatusNotify 交易状态变化通知
+	TxStatusNotify(tx *OutboxTx, previousStatus string) error
+}
+
+//TxOutbox 广播交易发件箱，跟踪交易直至确认，节点丢弃交易时自动重播
+type TxOutbox struct {
+	wm        *WalletManager
+	mu        sync.RWMutex
+	observers map[TxStatusObserver]bool
+
+	submitMu    sync.Mutex             //保护submitLocks
+	submitLocks map[string]*submitLock //同一来源地址同一时间只处理一个幂等提交
+}
+
+//submitLock 按来源地址加锁，refs为等待和持有的数量，归零后删除
+type submitLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+//NewTxOutbox 创建广播交易发件箱
+func NewTxOutbox(wm *WalletManager) *TxOutbox {
+	outbox := TxOutbox{}
+	outbox.wm = wm
+	outbox.observers = make(map[TxStatusObserver]bool)
+	outbox.submitLocks = make(map[string]*submitLock)
+	return &outbox
+}
+
+//AddObserver 添加观察者
+func (outbox *TxOutbox) AddObserver(obj TxStatusObserver) {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	if obj == nil {
+		return
+	}
+	outbox.observers[obj] = true
+}
+
+//RemoveObserver 移除观察者
+func (outbox *TxOutbox) RemoveObserver(obj TxStatusObserver) {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	delete(outbox.observers, obj)
+}
+
+//Add 记录已广播的交易
+func (outbox *TxOutbox) Add(txid, rawHex, accountID string) error {
+
+	db, err := outbox.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	record := &OutboxTx{
+		TxID:       txid,
+		RawHex:     rawHex,
+		AccountID:  accountID,
+		Status:     OutboxStatusPending,
+		SubmitTime: now,
+		UpdateTime: now,
+	}
+
+	err = db.From(outboxBucket).Save(record)
+	if err != nil {
+		return err
+	}
+
+	outbox.notify(record, "")
+	return nil
+}
+
+//Get 获取已广播的交易记录
+func (outbox *TxOutbox) Get(txid string) (*OutboxTx, error) {
+
+	db, err := outbox.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var record OutboxTx
+	err = db.From(outboxBucket).One("TxID", txid, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+//List 获取指定状态的交易记录，status为空则返回全部
+func (outbox *TxOutbox) List(status string) ([]*OutboxTx, error) {
+
+	db, err := outbox.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*OutboxTx
+	if len(status) == 0 {
+		err = db.From(outboxBucket).All(&list)
+	} else {
+		err = db.From(outboxBucket).Select(q.Eq("Status", status)).Find(&list)
+	}
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//Check 检查等待确认的交易，已确认的标记确认，不在交易池的重新广播
+func (outbox *TxOutbox) Check() {
+
+	pending, err := outbox.List(OutboxStatusPending)
+	if err != nil {
+		outbox.wm.Log.Std.Error("tx outbox can not get pending transactions; unexpected error: %v", err)
+		return
+	}
+
+	missing, err := outbox.List(OutboxStatusMissing)
+	if err != nil {
+		outbox.wm.Log.Std.Error("tx outbox can not get missing transactions; unexpected error: %v", err)
+		return
+	}
+	pending = append(pending, missing...)
+
+	if len(pending) == 0 {
+		return
+	}
+
+	txidsInPool, err := outbox.wm.Client.getTxIDsInPool()
+	if err != nil {
+		outbox.wm.Log.Std.Error("tx outbox can not get tx pool; unexpected error: %v", err)
+		return
+	}
+
+	inPool := make(map[string]bool)
+	for _, txid := range txidsInPool {
+		inPool[txid] = true
+	}
+
+	for _, record := range pending {
+		outbox.checkTx(record, inPool[record.TxID])
+	}
+}
+
+func (outbox *TxOutbox) checkTx(record *OutboxTx, inPool bool) {
+
+	trx, err := outbox.wm.Client.getTransaction(record.TxID)
+	if err == nil && trx.Confirmations > 0 {
+		outbox.transit(record, OutboxStatusConfirmed, "")
+		return
+	}
+
+	if inPool {
+		if record.Status == OutboxStatusMissing {
+			outbox.transit(record, OutboxStatusPending, "")
+		}
+		return
+	}
+
+	//交易既未确认也不在交易池，先通知离开交易池再重新广播
+	if record.Status == OutboxStatusPending {
+		outbox.transit(record, OutboxStatusMissing, "transaction is not in tx pool")
+	}
+
+	outbox.wm.Log.Std.Info("tx outbox rebroadcast transaction: %s", record.TxID)
+	_, err = outbox.wm.Client.sendTransaction(record.RawHex)
+
+	//节点已有该交易视为重播成功，不计入重播次数
+	if isAlreadyInPoolError(err) {
+		outbox.transit(record, OutboxStatusPending, "")
+		return
+	}
+
+	record.Rebroadcasts++
+	if err == nil {
+		outbox.transit(record, OutboxStatusPending, "")
+		return
+	}
+
+	if isConflictError(err) {
+		outbox.transit(record, OutboxStatusConflicted, err.Error())
+	} else if record.Rebroadcasts >= outbox.wm.Config.MaxRebroadcast {
+		outbox.transit(record, OutboxStatusDropped, err.Error())
+	} else {
+		outbox.transit(record, OutboxStatusMissing, err.Error())
+	}
+}
+
+//transit 更新交易状态并保存，状态变化时通知观察者
+func (outbox *TxOutbox) transit(record *OutboxTx, status, reason string) {
+	previousStatus := record.Status
+	record.Status = status
+	record.Reason = reason
+	outbox.update(record, previousStatus)
+}
+
+func (outbox *TxOutbox) update(record *OutboxTx, previousStatus string) {
+
+	db, err := outbox.wm.LocalDB.Open()
+	if err != nil {
+		outbox.wm.Log.Std.Error("tx outbox can not open db; unexpected error: %v", err)
+		return
+	}
+
+	record.UpdateTime = time.Now().Unix()
+	err = db.From(outboxBucket).Save(record)
+	if err != nil {
+		outbox.wm.Log.Std.Error("tx outbox can not save transaction: %s; unexpected error: %v", record.TxID, err)
+		return
+	}
+
+	if record.Status != previousStatus {
+		outbox.notify(record, previousStatus)
+	}
+}
+
+//notify 通知交易状态变化给观察者
+func (outbox *TxOutbox) notify(record *OutboxTx, previousStatus string) {
+	outbox.mu.RLock()
+	defer outbox.mu.RUnlock()
+
+	for o := range outbox.observers {
+		err := o.TxStatusNotify(record, previousStatus)
+		if err != nil {
+			outbox.wm.Log.Error("TxStatusNotify unexpected error:", err)
+		}
+	}
+}
+
+//lockSubmit 锁定来源地址的幂等提交，返回解锁函数。不同来源地址的提交可以同时广播。
+func (outbox *TxOutbox) lockSubmit(key string) func() {
+
+	outbox.submitMu.Lock()
+	lock := outbox.submitLocks[key]
+	if lock == nil {
+		lock = &submitLock{}
+		outbox.submitLocks[key] = lock
+	}
+	lock.refs++
+	outbox.submitMu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		outbox.submitMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(outbox.submitLocks, key)
+		}
+		outbox.submitMu.Unlock()
+	}
+}
+
+//GetSubmitRequest 获取请求ID对应的提交记录
+func (outbox *TxOutbox) GetSubmitRequest(requestID string) (*SubmitRequest, error) {
+
+	db, err := outbox.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var record SubmitRequest
+	err = db.From(submitRequestBucket).One("RequestID", requestID, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+//SaveSubmitRequest 保存请求ID对应的提交记录
+func (outbox *TxOutbox) SaveSubmitRequest(record *SubmitRequest) error {
+
+	db, err := outbox.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	return db.From(submitRequestBucket).Save(record)
+}
+
+//isAlreadyInPoolError 节点拒绝交易是否因为交易已存在
+func isAlreadyInPoolError(err error) bool {
+	if err == nil {
+		return false
+	}
+	reason := strings.ToLower(err.Error())
+	for _, key := range []string{"already have", "already exist", "already in"} {
+		if strings.Contains(reason, key) {
+			return true
+		}
+	}
+	return false
+}
+
+//isConflictError 节点拒绝交易是否因为输入已被花费
+func isConflictError(err error) bool {
+	if err == nil {
+		return false
+	}
+	reason := strings.ToLower(err.Error())
+	for _, key := range []string{"missing prev", "spent", "conflict", "double"} {
+		if strings.Contains(reason, key) {
+			return true
+		}
+	}
+	return false
+}
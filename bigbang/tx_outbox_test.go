@@ -0,0 +1,253 @@
+package bigbang
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestSubmitRawTransaction_Idempotent(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("sendtransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return "txid_1", nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	newRawTx := func(rawHex string) *openwallet.RawTransaction {
+		rawTx := &openwallet.RawTransaction{
+			RawHex:      rawHex,
+			IsCompleted: true,
+			Account:     &openwallet.AssetsAccount{AccountID: "account"},
+		}
+		rawTx.SetExtParam("requestID", "withdraw-1")
+		return rawTx
+	}
+
+	tx, err := wm.TxDecoder.SubmitRawTransaction(nil, newRawTx("raw_1"))
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction failed, unexpected error: %v", err)
+	}
+
+	//重试时交易单已重建，仍返回第一次广播的结果
+	retry := newRawTx("raw_2")
+	retryTx, err := wm.TxDecoder.SubmitRawTransaction(nil, retry)
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction retry failed, unexpected error: %v", err)
+	}
+
+	if retryTx.TxID != tx.TxID || retry.RawHex != "raw_1" {
+		t.Errorf("retry should return the first result, got txid: %s, rawHex: %s", retryTx.TxID, retry.RawHex)
+	}
+
+	if n := node.callCount("sendtransaction"); n != 1 {
+		t.Errorf("sendtransaction should be called once, got %d", n)
+	}
+}
+
+func TestSubmitRawTransaction_AlreadyInPool(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("sendtransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return nil, &mockRPCError{Code: -10, Message: "Tx rejected : Already have"}
+	})
+	node.handle("decodetransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"txid": "txid_in_pool"}, nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	rawTx := &openwallet.RawTransaction{
+		RawHex:      "raw_1",
+		IsCompleted: true,
+		Account:     &openwallet.AssetsAccount{AccountID: "account"},
+	}
+
+	tx, err := wm.TxDecoder.SubmitRawTransaction(nil, rawTx)
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction failed, unexpected error: %v", err)
+	}
+
+	if tx.TxID != "txid_in_pool" {
+		t.Errorf("txid should be decoded from raw hex, got: %s", tx.TxID)
+	}
+}
+
+//testStatusObserver 记录交易状态变化
+type testStatusObserver struct {
+	transitions []string
+}
+
+func (o *testStatusObserver) TxStatusNotify(tx *OutboxTx, previousStatus string) error {
+	o.transitions = append(o.transitions, previousStatus+"->"+tx.Status)
+	return nil
+}
+
+func TestTxOutbox_Check(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	var (
+		pool          []interface{}
+		confirmations int
+		sendErr       *mockRPCError
+	)
+	node.handle("gettxpool", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"list": pool}, nil
+	})
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction("txid_1", "1a", "1b", confirmations), nil
+	})
+	node.handle("sendtransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		if sendErr != nil {
+			return nil, sendErr
+		}
+		return "txid_1", nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+	wm.Config.MaxRebroadcast = 2
+
+	observer := &testStatusObserver{}
+	wm.TxOutbox.AddObserver(observer)
+
+	if err := wm.TxOutbox.Add("txid_1", "raw_1", "account"); err != nil {
+		t.Fatalf("Add failed, unexpected error: %v", err)
+	}
+
+	check := func(step string, status string, rebroadcasts int, transitions ...string) {
+		observer.transitions = nil
+		wm.TxOutbox.Check()
+		record, err := wm.TxOutbox.Get("txid_1")
+		if err != nil {
+			t.Fatalf("%s: Get failed, unexpected error: %v", step, err)
+		}
+		if record.Status != status || record.Rebroadcasts != rebroadcasts {
+			t.Errorf("%s: status %s, rebroadcasts %d; want %s, %d", step, record.Status, record.Rebroadcasts, status, rebroadcasts)
+		}
+		if strings.Join(observer.transitions, ",") != strings.Join(transitions, ",") {
+			t.Errorf("%s: transitions %v, want %v", step, observer.transitions, transitions)
+		}
+	}
+
+	//在交易池中不重播
+	pool = []interface{}{map[string]interface{}{"hex": "txid_1"}}
+	check("in pool", OutboxStatusPending, 0)
+
+	//离开交易池，通知后重播成功
+	pool = nil
+	check("rebroadcast", OutboxStatusPending, 1, "pending->missing", "missing->pending")
+
+	//节点已有该交易视为成功，不计入重播次数
+	sendErr = &mockRPCError{Code: -10, Message: "Tx rejected : Already have"}
+	check("already in pool", OutboxStatusPending, 1, "pending->missing", "missing->pending")
+
+	//重播失败保持离开交易池状态，达到最大次数后放弃
+	sendErr = &mockRPCError{Code: -10, Message: "Tx rejected : invalid"}
+	check("rebroadcast failed", OutboxStatusDropped, 2, "pending->missing", "missing->dropped")
+	check("dropped", OutboxStatusDropped, 2)
+}
+
+func TestTxOutbox_CheckMissing(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	var pool []interface{}
+	confirmations := 0
+	node.handle("gettxpool", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{"list": pool}, nil
+	})
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction("txid_1", "1a", "1b", confirmations), nil
+	})
+	node.handle("sendtransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return nil, &mockRPCError{Code: -10, Message: "Tx rejected : invalid"}
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+	wm.Config.MaxRebroadcast = 10
+
+	observer := &testStatusObserver{}
+	wm.TxOutbox.AddObserver(observer)
+	wm.TxOutbox.Add("txid_1", "raw_1", "account")
+	observer.transitions = nil
+
+	wm.TxOutbox.Check()
+	record, _ := wm.TxOutbox.Get("txid_1")
+	if record.Status != OutboxStatusMissing || record.Rebroadcasts != 1 {
+		t.Fatalf("failed rebroadcast should stay missing, got: %s, %d", record.Status, record.Rebroadcasts)
+	}
+
+	//交易重新出现在交易池后恢复等待确认，确认后标记确认
+	pool = []interface{}{map[string]interface{}{"hex": "txid_1"}}
+	wm.TxOutbox.Check()
+	confirmations = 1
+	wm.TxOutbox.Check()
+
+	want := "pending->missing,missing->pending,pending->confirmed"
+	if got := strings.Join(observer.transitions, ","); got != want {
+		t.Errorf("transitions %s, want %s", got, want)
+	}
+	if n := node.callCount("sendtransaction"); n != 1 {
+		t.Errorf("sendtransaction should be called once, got %d", n)
+	}
+}
+
+func TestSubmitRawTransaction_LockBySource(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("sendtransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return "txid_" + params["txdata"].(string), nil
+	})
+
+	wm := newTestWalletManager(t, node)
+	defer closeTestWalletManager(wm)
+
+	submit := func(from, requestID string) error {
+		rawTx := &openwallet.RawTransaction{
+			RawHex:      "raw_" + requestID,
+			IsCompleted: true,
+			TxFrom:      []string{from},
+			Account:     &openwallet.AssetsAccount{AccountID: "account"},
+		}
+		rawTx.SetExtParam("requestID", requestID)
+		_, err := wm.TxDecoder.SubmitRawTransaction(nil, rawTx)
+		return err
+	}
+
+	//1a的提交进行中时，1b的提交不等待
+	unlock := wm.TxOutbox.lockSubmit("1a")
+	done := make(chan error, 2)
+	go func() { done <- submit("1a", "withdraw-1") }()
+	go func() { done <- submit("1b", "withdraw-2") }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("SubmitRawTransaction failed, unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("submit from another source address should not be blocked")
+	}
+	if n := node.callCount("sendtransaction"); n != 1 {
+		t.Errorf("only 1b should be sent while 1a is locked, got %d", n)
+	}
+
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("SubmitRawTransaction failed, unexpected error: %v", err)
+	}
+	if len(wm.TxOutbox.submitLocks) != 0 {
+		t.Errorf("submit locks should be released, got: %d", len(wm.TxOutbox.submitLocks))
+	}
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	unscanRetryBucket = "unscan_retry"
+	maxRescanBackoff  = 24 * time.Hour //重扫间隔上限
+)
+
+//UnscanRetry 未扫记录的重扫状态，超过最大重扫次数后转入死信，等待人工处理
+type UnscanRetry struct {
+	ID          string `storm:"id"` //未扫记录ID
+	Fork        string //所属子链，主链为空
+	BlockHeight uint64
+	BlockHash   string //交易所在区块hash，重扫时交易仍归属原区块
+	TxID        string
+	Reason      string
+	Attempts    int
+	NextRetry   int64
+	Dead        bool `storm:"index"`
+	CreateAt    int64
+	UpdateTime  int64
+}
+
+//rescanBackoff 第attempts次失败后的重扫间隔，按指数增长
+func rescanBackoff(base time.Duration, attempts int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	backoff := base
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxRescanBackoff {
+			return maxRescanBackoff
+		}
+	}
+	return backoff
+}
+
+//RescanFailedRecord 重扫失败记录，按交易单逐条重试，失败后指数退避，超过最大次数转入死信
+func (bs *BBCBlockScanner) RescanFailedRecord() {
+
+	list, err := bs.getAllUnscanRecords()
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get rescan data; unexpected error: %v", err)
+		return
+	}
+
+	retries, err := bs.getUnscanRetries()
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get rescan state; unexpected error: %v", err)
+		return
+	}
+
+	now := time.Now()
+	exist := make(map[string]bool)
+
+	for _, record := range list {
+
+		exist[record.ID] = true
+
+		retry, ok := retries[record.ID]
+		if !ok {
+			retry = bs.newUnscanRetry(record, "")
+		}
+
+		if retry.Dead || now.Unix() < retry.NextRetry {
+			continue
+		}
+
+		err = bs.rescanRecord(retry)
+		if err == nil {
+			bs.BlockchainDAI.DeleteUnscanRecordByID(record.ID, record.Symbol)
+			bs.deleteUnscanRetry(retry)
+			continue
+		}
+
+		retry.Attempts++
+		retry.Reason = err.Error()
+		if retry.Attempts >= bs.wm.Config.MaxRescanAttempts {
+			//转入死信，不再自动重扫
+			retry.Dead = true
+			bs.BlockchainDAI.DeleteUnscanRecordByID(record.ID, record.Symbol)
+			bs.wm.Log.Std.Error("block height: %d tx: %s rescan failed %d times, moved to dead letter; unexpected error: %v", record.BlockHeight, record.TxID, retry.Attempts, err)
+		} else {
+			retry.NextRetry = now.Add(rescanBackoff(bs.wm.Config.RescanBackoff, retry.Attempts)).Unix()
+			bs.wm.Log.Std.Info("block height: %d tx: %s rescan failed %d times; unexpected error: %v", record.BlockHeight, record.TxID, retry.Attempts, err)
+		}
+		bs.saveUnscanRetry(retry)
+	}
+
+	//未扫记录已删除（如分叉回退）的重扫状态一并删除
+	for id, retry := range retries {
+		if !retry.Dead && !exist[id] {
+			bs.deleteUnscanRetry(retry)
+		}
+	}
+
+	//没有找到交易记录的未扫记录转入死信
+	bs.DeleteUnscanRecordNotFindTX()
+}
+
+//saveUnscanRecord 记录未扫记录和所在区块hash，已有重扫状态的不重置重扫次数。
+//死信重放失败重新记录未扫记录时，重扫状态恢复为待重扫，否则该记录不会再被重扫
+func (bs *BBCBlockScanner) saveUnscanRecord(fork string, height uint64, blockHash, txid, reason string) error {
+
+	record := openwallet.NewUnscanRecord(height, txid, reason, bs.forkSymbol(fork))
+	err := bs.SaveUnscanRecord(record)
+	if err != nil {
+		return err
+	}
+
+	retry, err := bs.getUnscanRetry(record.ID)
+	if err == storm.ErrNotFound {
+		bs.saveUnscanRetry(bs.newUnscanRetry(record, blockHash))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	if retry.Dead {
+		retry.Dead = false
+		retry.Attempts = 0
+		retry.NextRetry = 0
+		retry.Reason = reason
+		changed = true
+	}
+	if len(retry.BlockHash) == 0 && len(blockHash) > 0 {
+		retry.BlockHash = blockHash
+		changed = true
+	}
+	if changed {
+		bs.saveUnscanRetry(retry)
+	}
+	return nil
+}
+
+//newUnscanRetry 创建未扫记录的重扫状态
+func (bs *BBCBlockScanner) newUnscanRetry(record *openwallet.UnscanRecord, blockHash string) *UnscanRetry {
+	return &UnscanRetry{
+		ID:          record.ID,
+		Fork:        bs.symbolFork(record.Symbol),
+		BlockHeight: record.BlockHeight,
+		BlockHash:   blockHash,
+		TxID:        record.TxID,
+		Reason:      record.Reason,
+		CreateAt:    time.Now().Unix(),
+	}
+}
+
+//moveUnscanRecordToDead 未扫记录直接转入死信，不再自动重扫
+func (bs *BBCBlockScanner) moveUnscanRecordToDead(record *openwallet.UnscanRecord) error {
+
+	retry, err := bs.getUnscanRetry(record.ID)
+	if err == storm.ErrNotFound {
+		retry = bs.newUnscanRetry(record, "")
+	} else if err != nil {
+		return err
+	}
+
+	retry.Dead = true
+	retry.Reason = record.Reason
+	bs.saveUnscanRetry(retry)
+
+	bs.wm.Log.Std.Error("block height: %d tx: %s can not be rescanned, moved to dead letter; reason: %s", record.BlockHeight, record.TxID, record.Reason)
+
+	return bs.BlockchainDAI.DeleteUnscanRecordByID(record.ID, record.Symbol)
+}
+
+//unscanBlockHash 未扫记录所在区块的hash，没有记录的从本地区块获取
+func (bs *BBCBlockScanner) unscanBlockHash(retry *UnscanRetry) (string, error) {
+
+	if len(retry.BlockHash) > 0 {
+		return retry.BlockHash, nil
+	}
+
+	block, err := bs.getForkLocalBlock(retry.Fork, retry.BlockHeight)
+	if err == nil {
+		return block.Hash, nil
+	}
+
+	//分叉回退会删除孤块的未扫记录，节点同一高度的区块即为所在区块
+	block, err = bs.wm.Client.getForkBlockByHeight(retry.BlockHeight, retry.Fork)
+	if err != nil {
+		return "", err
+	}
+	return block.Hash, nil
+}
+
+//rescanRecord 重扫一条未扫记录，有交易单则只提取该交易单，否则重扫整个区块
+func (bs *BBCBlockScanner) rescanRecord(retry *UnscanRetry) error {
+
+	fork := retry.Fork
+
+	if len(retry.TxID) > 0 {
+		bs.wm.Log.Std.Info("block scanner rescanning height: %d tx: %s ...", retry.BlockHeight, retry.TxID)
+
+		blockHash, err := bs.unscanBlockHash(retry)
+		if err != nil {
+			return err
+		}
+
+		result := bs.ExtractTransaction(retry.BlockHeight, blockHash, retry.TxID, bs.ScanAddressFunc, false)
+		result.fork = fork
+		if !result.Success {
+			return errors.New(result.Reason)
+		}
+		if !bs.notifyExtractResult(retry.BlockHeight, &result) {
+			return errors.New(result.Reason)
+		}
+		//与扫描区块一样记录已提取的交易单，分叉时通知失效
+		if len(result.extractData) > 0 {
+			bs.addExtractedTxID(fork, retry.BlockHeight, blockHash, retry.TxID)
+		}
+		return nil
+	}
+
+	if retry.BlockHeight == 0 {
+		return nil
+	}
+
+	bs.wm.Log.Std.Info("block scanner rescanning height: %d ...", retry.BlockHeight)
+
+	block, err := bs.wm.Client.getForkBlockByHeight(retry.BlockHeight, fork)
+	if err != nil {
+		return err
+	}
+	if len(fork) > 0 {
+		block.Fork = fork
+	}
+
+	err = bs.extractBlock(block)
+	if err != nil {
+		//区块内提取失败的交易单已各自记录未扫记录，区块记录完成
+		if _, ok := err.(*ExtractError); ok {
+			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+//GetDeadUnscanRecords 获取超过最大重扫次数的死信记录
+func (bs *BBCBlockScanner) GetDeadUnscanRecords() ([]*UnscanRetry, error) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*UnscanRetry
+	err = db.From(unscanRetryBucket).Select(q.Eq("Dead", true)).Find(&list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
+
+//ReplayDeadUnscanRecord 人工重放死信记录，成功则删除，失败则保留在死信中并更新失败原因。
+//通知失败重新记录了未扫记录的，恢复为待重扫由RescanFailedRecord继续重扫
+func (bs *BBCBlockScanner) ReplayDeadUnscanRecord(id string) error {
+
+	retry, err := bs.getUnscanRetry(id)
+	if err != nil {
+		return err
+	}
+
+	if !retry.Dead {
+		return fmt.Errorf("unscan record: %s is not in dead letter", id)
+	}
+
+	err = bs.rescanRecord(retry)
+	if err == nil {
+		bs.deleteUnscanRetry(retry)
+		return nil
+	}
+
+	//提取成功但通知失败时已重新记录未扫记录，重扫状态已恢复为待重扫
+	if current, getErr := bs.getUnscanRetry(id); getErr == nil && !current.Dead {
+		return err
+	}
+
+	retry.Reason = err.Error()
+	bs.saveUnscanRetry(retry)
+
+	return err
+}
+
+//ReplayDeadUnscanRecords 人工重放全部死信记录，返回重放失败的数量
+func (bs *BBCBlockScanner) ReplayDeadUnscanRecords() (int, error) {
+
+	list, err := bs.GetDeadUnscanRecords()
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, retry := range list {
+		err = bs.ReplayDeadUnscanRecord(retry.ID)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not replay unscan record: %s; unexpected error: %v", retry.ID, err)
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+//getUnscanRetries 获取全部重扫状态，key为未扫记录ID
+func (bs *BBCBlockScanner) getUnscanRetries() (map[string]*UnscanRetry, error) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*UnscanRetry
+	err = db.From(unscanRetryBucket).All(&list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	retries := make(map[string]*UnscanRetry)
+	for _, retry := range list {
+		retries[retry.ID] = retry
+	}
+	return retries, nil
+}
+
+//getUnscanRetry 获取未扫记录的重扫状态
+func (bs *BBCBlockScanner) getUnscanRetry(id string) (*UnscanRetry, error) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var retry UnscanRetry
+	err = db.From(unscanRetryBucket).One("ID", id, &retry)
+	if err != nil {
+		return nil, err
+	}
+	return &retry, nil
+}
+
+//saveUnscanRetry 保存重扫状态
+func (bs *BBCBlockScanner) saveUnscanRetry(retry *UnscanRetry) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not open db; unexpected error: %v", err)
+		return
+	}
+
+	retry.UpdateTime = time.Now().Unix()
+	err = db.From(unscanRetryBucket).Save(retry)
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not save rescan state: %s; unexpected error: %v", retry.ID, err)
+	}
+}
+
+//deleteUnscanRetry 删除重扫状态
+func (bs *BBCBlockScanner) deleteUnscanRetry(retry *UnscanRetry) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not open db; unexpected error: %v", err)
+		return
+	}
+
+	err = db.From(unscanRetryBucket).DeleteStruct(retry)
+	if err != nil && err != storm.ErrNotFound {
+		bs.wm.Log.Std.Error("block scanner can not delete rescan state: %s; unexpected error: %v", retry.ID, err)
+	}
+}
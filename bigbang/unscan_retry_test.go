@@ -0,0 +1,246 @@
+package bigbang
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRescanBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, maxRescanBackoff},
+	}
+	for _, test := range tests {
+		if got := rescanBackoff(time.Minute, test.attempts); got != test.want {
+			t.Errorf("rescanBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestRescanFailedRecord_DeadLetter(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	available := false
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		if !available {
+			return nil, &mockRPCError{Code: -6, Message: "node busy"}
+		}
+		return testTransaction("txid_1", "1sender", "1deposit", 3), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Config.MaxRescanAttempts = 2
+	wm.Config.RescanBackoff = 0
+
+	bs := wm.Blockscanner
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+
+	//提取失败按交易单记录真实原因
+	result := bs.ExtractTransaction(10, "hash_10", "txid_1", bs.ScanAddressFunc, false)
+	bs.notifyExtractResult(10, &result)
+
+	records, _ := dai.GetUnscanRecords(wm.Symbol())
+	if len(records) != 1 || records[0].TxID != "txid_1" || records[0].Reason != "[-6]node busy" {
+		t.Fatalf("unscan record should be saved per txid with reason, got: %+v", records)
+	}
+
+	bs.RescanFailedRecord()
+	records, _ = dai.GetUnscanRecords(wm.Symbol())
+	if len(records) != 1 {
+		t.Fatalf("unscan record should be kept before max attempts, got: %d", len(records))
+	}
+
+	//超过最大次数转入死信
+	bs.RescanFailedRecord()
+	records, _ = dai.GetUnscanRecords(wm.Symbol())
+	if len(records) != 0 {
+		t.Fatalf("unscan record should be moved to dead letter, got: %d", len(records))
+	}
+	dead, err := bs.GetDeadUnscanRecords()
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("dead letter should have one record with 2 attempts, got: %+v, err: %v", dead, err)
+	}
+
+	//死信不再自动重扫
+	calls := node.callCount("gettransaction")
+	bs.RescanFailedRecord()
+	if node.callCount("gettransaction") != calls {
+		t.Errorf("dead letter should not be rescanned automatically")
+	}
+
+	//人工重放失败仍保留在死信中，不恢复自动重扫
+	if err := bs.ReplayDeadUnscanRecord(dead[0].ID); err == nil {
+		t.Fatalf("ReplayDeadUnscanRecord should fail while the node is busy")
+	}
+	dead, _ = bs.GetDeadUnscanRecords()
+	if len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("failed replay should stay in dead letter, got: %+v", dead)
+	}
+	if records, _ := dai.GetUnscanRecords(wm.Symbol()); len(records) != 0 {
+		t.Fatalf("failed replay should not restore unscan record, got: %d", len(records))
+	}
+
+	//人工重放成功后删除
+	available = true
+	err = bs.ReplayDeadUnscanRecord(dead[0].ID)
+	if err != nil {
+		t.Fatalf("ReplayDeadUnscanRecord failed, unexpected error: %v", err)
+	}
+	dead, _ = bs.GetDeadUnscanRecords()
+	if len(dead) != 0 {
+		t.Errorf("dead letter should be empty after replay, got: %d", len(dead))
+	}
+	txs := observer.transactions()
+	if len(txs) != 1 || txs[0].BlockHash != "hash_10" || txs[0].BlockHeight != 10 {
+		t.Errorf("replayed transaction should be notified once with its block, got: %+v", txs)
+	}
+}
+
+func TestReplayDeadUnscanRecord_Requeue(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return testTransaction("txid_1", "1sender", "1deposit", 3), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Config.MaxRescanAttempts = 1
+	wm.Config.RescanBackoff = 0
+
+	bs := wm.Blockscanner
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+
+	//通知失败记录未扫记录，重扫仍失败后转入死信
+	observer.setError(errors.New("observer down"))
+	result := bs.ExtractTransaction(10, "hash_10", "txid_1", bs.ScanAddressFunc, false)
+	bs.notifyExtractResult(10, &result)
+	bs.RescanFailedRecord()
+	dead, _ := bs.GetDeadUnscanRecords()
+	if len(dead) != 1 {
+		t.Fatalf("unscan record should be moved to dead letter, got: %d", len(dead))
+	}
+
+	//重放时通知失败重新记录未扫记录，恢复为待重扫
+	if err := bs.ReplayDeadUnscanRecord(dead[0].ID); err == nil {
+		t.Fatalf("ReplayDeadUnscanRecord should fail while the observer is down")
+	}
+	if dead, _ := bs.GetDeadUnscanRecords(); len(dead) != 0 {
+		t.Fatalf("re-queued record should leave dead letter, got: %+v", dead)
+	}
+	if records, _ := dai.GetUnscanRecords(wm.Symbol()); len(records) != 1 {
+		t.Fatalf("re-queued record should be rescanned again, got: %d", len(records))
+	}
+
+	//重扫成功后记录到区块已提取的交易单，分叉时可通知失效
+	observer.setError(nil)
+	bs.RescanFailedRecord()
+	if records, _ := dai.GetUnscanRecords(wm.Symbol()); len(records) != 0 {
+		t.Fatalf("unscan record should be deleted after rescan, got: %d", len(records))
+	}
+	if txs := observer.transactions(); len(txs) != 1 {
+		t.Errorf("rescanned transaction should be notified once, got: %d", len(txs))
+	}
+	txids, _ := bs.getBlockTxIDs("hash_10")
+	if len(txids) != 1 || txids[0] != "txid_1" {
+		t.Errorf("rescanned transaction should be recorded in its block, got: %v", txids)
+	}
+}
+
+func TestRescanFailedRecord_KeepBlockHash(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	available := false
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		if !available {
+			return nil, &mockRPCError{Code: -6, Message: "node busy"}
+		}
+		return testTransaction("txid_1", "1sender", "1deposit", 3), nil
+	})
+
+	wm, observer := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Config.RescanBackoff = 0
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+
+	result := bs.ExtractTransaction(10, "hash_10", "txid_1", bs.ScanAddressFunc, false)
+	bs.notifyExtractResult(10, &result)
+
+	available = true
+	bs.RescanFailedRecord()
+
+	txs := observer.transactions()
+	if len(txs) != 1 || txs[0].BlockHash != "hash_10" {
+		t.Errorf("rescanned transaction should keep its block hash, got: %+v", txs)
+	}
+}
+
+func TestRescanFailedRecord_NotFoundToDeadLetter(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return nil, &mockRPCError{Code: -5, Message: "No information available about transaction"}
+	})
+
+	wm, _ := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Config.RescanBackoff = 0
+
+	bs := wm.Blockscanner
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+
+	result := bs.ExtractTransaction(10, "hash_10", "txid_1", bs.ScanAddressFunc, false)
+	bs.notifyExtractResult(10, &result)
+
+	//找不到的交易单转入死信，不直接删除
+	bs.RescanFailedRecord()
+	if records, _ := dai.GetUnscanRecords(wm.Symbol()); len(records) != 0 {
+		t.Fatalf("not found tx should be removed from unscan records, got: %d", len(records))
+	}
+	dead, _ := bs.GetDeadUnscanRecords()
+	if len(dead) != 1 || dead[0].TxID != "txid_1" || dead[0].BlockHash != "hash_10" {
+		t.Errorf("not found tx should be moved to dead letter, got: %+v", dead)
+	}
+}
+
+func TestRescanFailedRecord_Backoff(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return nil, &mockRPCError{Code: -6, Message: "node busy"}
+	})
+
+	wm, _ := newTestScanner(t, node, "1deposit")
+	defer closeTestWalletManager(wm)
+	wm.Config.RescanBackoff = time.Hour
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(newTestBlockchainDAI())
+
+	result := bs.ExtractTransaction(10, "hash_10", "txid_1", bs.ScanAddressFunc, false)
+	bs.notifyExtractResult(10, &result)
+
+	calls := node.callCount("gettransaction")
+	bs.RescanFailedRecord()
+	bs.RescanFailedRecord()
+	if n := node.callCount("gettransaction") - calls; n != 1 {
+		t.Errorf("record should not be retried before backoff, got %d retries", n)
+	}
+}
@@ -0,0 +1,391 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package bigbang
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+const (
+	utxoBucket      = "utxo"
+	utxoSpentBucket = "utxo_spent"
+)
+
+//UTXO 本地维护的已监听地址的交易输出，只记录已上链的交易
+type UTXO struct {
+	ID          string `storm:"id"` //txid:vout
+	TxID        string
+	Vout        uint8
+	Address     string `storm:"index"`
+	Anchor      string
+	Amount      uint64
+	LockUntil   uint32 //锁定到的区块高度，0为不锁定
+	BlockHeight uint64
+	BlockHash   string `storm:"index"`
+	CreateAt    int64
+}
+
+//UTXOSpent 已监听地址的输出被花费的记录。
+//与输出分开记录，同一区块内花费先于输出被提取时也不影响结果。
+type UTXOSpent struct {
+	ID          string `storm:"id"` //被花费输出的txid:vout
+	SpentTxID   string
+	BlockHeight uint64
+	BlockHash   string `storm:"index"`
+	CreateAt    int64
+}
+
+//UTXOBalance 本地UTXO集合统计的地址余额，扫描交易池时扣除交易池中已花费的输出
+type UTXOBalance struct {
+	Address     string
+	Confirmed   uint64 //已上链未花费的输出，包含锁定的部分
+	Locked      uint64 //未到解锁高度的输出
+	Unconfirmed uint64 //交易池中转入的输出，包含找零
+}
+
+//Spendable 可花费余额
+func (b *UTXOBalance) Spendable() uint64 {
+	return b.Confirmed - b.Locked
+}
+
+//updateUTXOs 记录交易花费的已监听地址输出和转入已监听地址的输出
+func (bs *BBCBlockScanner) updateUTXOs(trx *Transaction, inputs []*OutPoint, owned []*OutPoint) error {
+
+	if !bs.wm.Config.LocalUTXO || (len(inputs) == 0 && len(owned) == 0) {
+		return nil
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	createAt := time.Now().Unix()
+
+	for _, input := range inputs {
+		//节点没有返回vin时无法确定花费的输出
+		if len(input.TxID) == 0 {
+			continue
+		}
+		err = tx.From(utxoSpentBucket).Save(&UTXOSpent{
+			ID:          input.ID,
+			SpentTxID:   trx.TxID,
+			BlockHeight: trx.BlockHeight,
+			BlockHash:   trx.BlockHash,
+			CreateAt:    createAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, point := range owned {
+		utxo := &UTXO{
+			ID:          point.ID,
+			TxID:        point.TxID,
+			Vout:        point.Vout,
+			Address:     point.Address,
+			Anchor:      trx.Anchor,
+			Amount:      point.Amount,
+			BlockHeight: trx.BlockHeight,
+			BlockHash:   trx.BlockHash,
+			CreateAt:    createAt,
+		}
+		//锁定高度只作用于接收方输出
+		if point.Vout == VoutTo {
+			utxo.LockUntil = trx.LockUntil
+		}
+		err = tx.From(utxoBucket).Save(utxo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//deleteBlockUTXOs 删除孤块中产生的输出和花费记录
+func (bs *BBCBlockScanner) deleteBlockUTXOs(hash string) error {
+
+	if !bs.wm.Config.LocalUTXO || len(hash) == 0 {
+		return nil
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return err
+	}
+
+	err = db.From(utxoBucket).Select(q.Eq("BlockHash", hash)).Delete(new(UTXO))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	err = db.From(utxoSpentBucket).Select(q.Eq("BlockHash", hash)).Delete(new(UTXOSpent))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//unspentUTXOs 获取地址在指定分支上未花费的输出
+func (bs *BBCBlockScanner) unspentUTXOs(address, anchor string) ([]*UTXO, error) {
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*UTXO
+	err = db.From(utxoBucket).Find("Address", address, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	unspent := make([]*UTXO, 0, len(list))
+
+	for _, utxo := range list {
+		if utxo.Anchor != anchor {
+			continue
+		}
+
+		var spent UTXOSpent
+		err = db.From(utxoSpentBucket).One("ID", utxo.ID, &spent)
+		if err == nil {
+			continue
+		}
+		if err != storm.ErrNotFound {
+			return nil, err
+		}
+
+		unspent = append(unspent, utxo)
+	}
+
+	sort.Slice(unspent, func(i, j int) bool {
+		if unspent[i].BlockHeight != unspent[j].BlockHeight {
+			return unspent[i].BlockHeight < unspent[j].BlockHeight
+		}
+		return unspent[i].ID < unspent[j].ID
+	})
+
+	return unspent, nil
+}
+
+//poolUTXOs 从交易池记录统计地址在指定分支上被交易池花费的输出和交易池中转入的金额。
+//只有扫描交易池时才有交易池记录，已不在交易池的交易在记录过期清理前仍会计入。
+func (bs *BBCBlockScanner) poolUTXOs(address, anchor string) (map[string]bool, uint64, error) {
+
+	spent := make(map[string]bool)
+	if !bs.IsScanMemPool {
+		return spent, 0, nil
+	}
+
+	db, err := bs.wm.LocalDB.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []*MemPoolTx
+	err = db.From(memPoolBucket).All(&records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, 0, err
+	}
+
+	var unconfirmed uint64
+	for _, record := range records {
+		if record.Anchor != anchor {
+			continue
+		}
+		for _, id := range record.Spent {
+			spent[id] = true
+		}
+		for _, point := range record.Outputs {
+			if point.Address != address {
+				continue
+			}
+			//交易已上链写入UTXO，交易池记录尚未删除
+			var utxo UTXO
+			err = db.From(utxoBucket).One("ID", point.ID, &utxo)
+			if err == nil {
+				continue
+			}
+			if err != storm.ErrNotFound {
+				return nil, 0, err
+			}
+			unconfirmed += point.Amount
+		}
+	}
+	return spent, unconfirmed, nil
+}
+
+//anchorScannedHeight 分支已扫描的高度，用于计算锁定，主链为主扫描高度，子链为子链自己的扫描高度
+func (bs *BBCBlockScanner) anchorScannedHeight(anchor string) (uint64, error) {
+
+	mainAnchor, err := bs.wm.getAnchor()
+	if err != nil {
+		return 0, err
+	}
+
+	if anchor == mainAnchor {
+		height, _, err := bs.GetLocalNewBlock()
+		return height, err
+	}
+
+	header, err := bs.GetSubForkScannedBlockHeader(anchor)
+	if err != nil {
+		return 0, err
+	}
+	return header.Height, nil
+}
+
+//GetUTXOBalance 从本地UTXO集合统计地址在指定分支上的余额，锁定按该分支已扫描高度计算。
+//扫描交易池时，交易池中已花费的输出不计入已确认余额，转入的输出计入未确认余额。
+func (bs *BBCBlockScanner) GetUTXOBalance(address, anchor string) (*UTXOBalance, error) {
+
+	height, err := bs.anchorScannedHeight(anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	unspent, err := bs.unspentUTXOs(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	poolSpent, unconfirmed, err := bs.poolUTXOs(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := &UTXOBalance{Address: address, Unconfirmed: unconfirmed}
+	for _, utxo := range unspent {
+		if poolSpent[utxo.ID] {
+			continue
+		}
+		balance.Confirmed += utxo.Amount
+		if uint64(utxo.LockUntil) > height {
+			balance.Locked += utxo.Amount
+		}
+	}
+	return balance, nil
+}
+
+//listLocalUnspent 从本地UTXO集合获取地址已解锁的输出，扫描交易池时跳过交易池中已花费的输出
+func (bs *BBCBlockScanner) listLocalUnspent(address, anchor string) ([]UnSpent, error) {
+
+	height, err := bs.anchorScannedHeight(anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	unspent, err := bs.unspentUTXOs(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	poolSpent, _, err := bs.poolUTXOs(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]UnSpent, 0, len(unspent))
+	for _, utxo := range unspent {
+		if uint64(utxo.LockUntil) > height || poolSpent[utxo.ID] {
+			continue
+		}
+		ret = append(ret, UnSpent{
+			TxID:      utxo.TxID,
+			Vout:      utxo.Vout,
+			Amount:    utxo.Amount,
+			LockUntil: utxo.LockUntil,
+		})
+	}
+	return ret, nil
+}
+
+//getAddressBalance 获取地址余额，开启localUTXO时使用本地UTXO集合，否则查询节点
+func (wm *WalletManager) getAddressBalance(address, anchor string) (*AddrBalance, error) {
+
+	if !wm.Config.LocalUTXO {
+		return wm.Client.getBalance(address, anchor)
+	}
+
+	balance, err := wm.Blockscanner.GetUTXOBalance(address, anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AddrBalance{
+		Address:     address,
+		Balance:     new(big.Int).SetUint64(balance.Spendable()),
+		Locked:      new(big.Int).SetUint64(balance.Locked),
+		Unconfirmed: new(big.Int).SetUint64(balance.Unconfirmed),
+	}, nil
+}
+
+//listUnspent 获取地址可花费的输出，开启localUTXO时使用本地UTXO集合，否则查询节点
+func (wm *WalletManager) listUnspent(address, anchor string) ([]UnSpent, error) {
+
+	if !wm.Config.LocalUTXO {
+		utxos, err := wm.Client.listUnnSpent(address, anchor)
+		if err != nil {
+			return nil, err
+		}
+		return wm.filterLockedUnspent(utxos, anchor)
+	}
+
+	return wm.Blockscanner.listLocalUnspent(address, anchor)
+}
+
+//filterLockedUnspent 过滤节点返回的未到解锁高度的输出，锁定高度按所在分支的当前高度计算
+func (wm *WalletManager) filterLockedUnspent(utxos []UnSpent, anchor string) ([]UnSpent, error) {
+
+	locked := false
+	for _, utxo := range utxos {
+		if utxo.LockUntil > 0 {
+			locked = true
+			break
+		}
+	}
+	if !locked {
+		return utxos, nil
+	}
+
+	height, err := wm.getAnchorBlockHeight(anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	spendable := make([]UnSpent, 0, len(utxos))
+	for _, utxo := range utxos {
+		if uint64(utxo.LockUntil) > height {
+			continue
+		}
+		spendable = append(spendable, utxo)
+	}
+	return spendable, nil
+}
@@ -0,0 +1,192 @@
+package bigbang
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestLocalUTXO_BalanceAndUnspent(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	locked := testVinTransaction("txid_l", "1sender", "1hot", "5", "txid_ext", "1")
+	locked["transaction"].(map[string]interface{})["lockuntil"] = 100
+	txs := map[string]map[string]interface{}{
+		"txid_a": testVinTransaction("txid_a", "1sender", "1hot", "10", "txid_ext", "0"),
+		"txid_l": locked,
+		"txid_b": testVinTransaction("txid_b", "1hot", "1user", "3", "txid_a", "0"),
+	}
+	node.handle("gettransaction", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return txs[params["txid"].(string)], nil
+	})
+
+	wm, _ := newTestScanner(t, node, "1hot")
+	defer closeTestWalletManager(wm)
+	wm.Config.LocalUTXO = true
+
+	bs := wm.Blockscanner
+	bs.IsScanMemPool = true
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Height: 11, Hash: "hash_11", Symbol: wm.Symbol()})
+	anchor := wm.Config.Anchor
+
+	extract := func(height uint64, txid string, memPool bool) {
+		hash := ""
+		if height > 0 {
+			hash = fmt.Sprintf("hash_%d", height)
+		}
+		result := bs.ExtractTransaction(height, hash, txid, bs.ScanAddressFunc, memPool)
+		if !result.Success {
+			t.Fatalf("ExtractTransaction %s failed: %s", txid, result.Reason)
+		}
+	}
+
+	checkBalance := func(step string, confirmed, locked, spendable, unconfirmed uint64) {
+		balance, err := bs.GetUTXOBalance("1hot", anchor)
+		if err != nil {
+			t.Fatalf("%s: GetUTXOBalance failed: %v", step, err)
+		}
+		if balance.Confirmed != confirmed || balance.Locked != locked || balance.Spendable() != spendable || balance.Unconfirmed != unconfirmed {
+			t.Errorf("%s: unexpected balance: %+v, spendable: %d", step, balance, balance.Spendable())
+		}
+	}
+
+	extract(10, "txid_a", false)
+	extract(10, "txid_l", false)
+	checkBalance("received", 15000000, 5000000, 10000000, 0)
+
+	//交易池中的转出不写入本地UTXO集合，花费的输出不再计入，找零计入未确认余额
+	err := bs.BatchExtractTransaction(0, "", []string{"txid_b"}, true)
+	if err != nil {
+		t.Fatalf("BatchExtractTransaction failed, unexpected error: %v", err)
+	}
+	checkBalance("mempool", 5000000, 5000000, 0, 6990000)
+	unspent, _ := bs.listLocalUnspent("1hot", anchor)
+	if len(unspent) != 0 {
+		t.Errorf("outputs spent in mempool should not be spendable, got: %+v", unspent)
+	}
+
+	balances, err := bs.GetBalanceDetailByAddress("1hot")
+	if err != nil || len(balances) != 1 {
+		t.Fatalf("GetBalanceDetailByAddress failed: %v", err)
+	}
+	if b := balances[0]; b.Balance.Balance != "11.99" || b.ConfirmBalance != "0" || b.UnconfirmBalance != "6.99" || b.LockedBalance != "5" {
+		t.Errorf("unconfirmed balance should be served from mempool records, got: %+v, locked: %s", b.Balance, b.LockedBalance)
+	}
+
+	//上链后找零可花费
+	err = bs.BatchExtractTransaction(11, "hash_11", []string{"txid_b"}, false)
+	if err != nil {
+		t.Fatalf("BatchExtractTransaction failed, unexpected error: %v", err)
+	}
+	checkBalance("confirmed", 11990000, 5000000, 6990000, 0)
+	unspent, _ = bs.listLocalUnspent("1hot", anchor)
+	if len(unspent) != 1 || unspent[0].TxID != "txid_b" || unspent[0].Vout != VoutChange || unspent[0].Amount != 6990000 {
+		t.Errorf("change output should be spendable, got: %+v", unspent)
+	}
+
+	balances, err = bs.GetBalanceDetailByAddress("1hot")
+	if err != nil || len(balances) != 1 {
+		t.Fatalf("GetBalanceDetailByAddress failed: %v", err)
+	}
+	if b := balances[0]; b.Balance.Balance != "11.99" || b.ConfirmBalance != "6.99" || b.UnconfirmBalance != "0" || b.LockedBalance != "5" {
+		t.Errorf("balance should be served from local utxo set, got: %+v, locked: %s", b.Balance, b.LockedBalance)
+	}
+
+	//孤块回滚后恢复被花费的输出
+	bs.deleteBlockUTXOs("hash_11")
+	checkBalance("rollback", 15000000, 5000000, 10000000, 0)
+}
+
+func TestLocalUTXO_SubForkLock(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	wm, _ := newTestScanner(t, node, "1hot")
+	defer closeTestWalletManager(wm)
+	wm.Config.LocalUTXO = true
+
+	bs := wm.Blockscanner
+	dai := newTestBlockchainDAI()
+	bs.SetBlockchainDAI(dai)
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Height: 100, Hash: "hash_100", Symbol: wm.Symbol()})
+
+	//子链高度低于主链，锁定按子链自己的高度计算
+	fork := "fork_1"
+	bs.SaveSubForkScannedBlockHeader(fork, 20, "fork_hash_20")
+	trx := &Transaction{TxID: "txid_f", Anchor: fork, To: "1hot", Amount: 2000000, LockUntil: 50, BlockHeight: 10, BlockHash: "fork_hash_10"}
+	err := bs.updateUTXOs(trx, nil, txVouts(trx, 0))
+	if err != nil {
+		t.Fatalf("updateUTXOs failed, unexpected error: %v", err)
+	}
+
+	balance, err := bs.GetUTXOBalance("1hot", fork)
+	if err != nil {
+		t.Fatalf("GetUTXOBalance failed: %v", err)
+	}
+	if balance.Confirmed != 2000000 || balance.Locked != 2000000 {
+		t.Errorf("output should be locked until the sub fork height, got: %+v", balance)
+	}
+	if unspent, _ := bs.listLocalUnspent("1hot", fork); len(unspent) != 0 {
+		t.Errorf("locked sub fork output should not be spendable, got: %+v", unspent)
+	}
+
+	bs.SaveSubForkScannedBlockHeader(fork, 50, "fork_hash_50")
+	if unspent, _ := bs.listLocalUnspent("1hot", fork); len(unspent) != 1 {
+		t.Errorf("sub fork output should be spendable at the lock height, got: %+v", unspent)
+	}
+}
+
+func TestGetBalanceDetailByAddress(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("getbalance", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return []interface{}{map[string]interface{}{"avail": "7.5", "locked": "2", "unconfirmed": "0.5"}}, nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+
+	balances, err := wm.Blockscanner.GetBalanceDetailByAddress("1hot")
+	if err != nil || len(balances) != 1 {
+		t.Fatalf("GetBalanceDetailByAddress failed: %v", err)
+	}
+	b := balances[0]
+	if b.Balance.Balance != "10" || b.ConfirmBalance != "7.5" || b.UnconfirmBalance != "0.5" || b.LockedBalance != "2" {
+		t.Errorf("unexpected balance: %+v, locked: %s", b.Balance, b.LockedBalance)
+	}
+}
+
+func TestListUnspent_SkipLocked(t *testing.T) {
+	node := newMockNode()
+	defer node.Close()
+
+	node.handle("listunspent", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return map[string]interface{}{
+			"unspents": []interface{}{
+				map[string]interface{}{"txid": "txid_a", "out": 0, "amount": "1", "lockuntil": 0},
+				map[string]interface{}{"txid": "txid_b", "out": 0, "amount": "2", "lockuntil": 200},
+				map[string]interface{}{"txid": "txid_c", "out": 1, "amount": "3", "lockuntil": 50},
+			},
+			"sum": "6",
+		}, nil
+	})
+	node.handle("getblockcount", func(params map[string]interface{}) (interface{}, *mockRPCError) {
+		return 101, nil
+	})
+
+	wm, _ := newTestScanner(t, node)
+	defer closeTestWalletManager(wm)
+
+	utxos, err := wm.listUnspent("1hot", wm.Config.Anchor)
+	if err != nil {
+		t.Fatalf("listUnspent failed: %v", err)
+	}
+	if len(utxos) != 2 || utxos[0].TxID != "txid_a" || utxos[1].TxID != "txid_c" {
+		t.Errorf("outputs locked over current height should be skipped, got: %+v", utxos)
+	}
+}
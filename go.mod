@@ -9,11 +9,12 @@ require (
 	github.com/blocktree/go-owcrypt v1.1.9
 	github.com/blocktree/openwallet v1.5.5
 	github.com/ethereum/go-ethereum v1.8.25
-	github.com/graarh/golang-socketio v0.0.0-20170510162725-2c44953b9b5f
+	github.com/gorilla/websocket v1.4.0
 	github.com/imroc/req v0.2.3
 	github.com/pborman/uuid v1.2.0
 	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24
 	github.com/tidwall/gjson v1.2.1
+	go.etcd.io/bbolt v1.3.5 // indirect
 )
 
 // replace github.com/blocktree/go-owcdrivers => /Users/heshuchao/workspace/go-workspace/projects/src/github.com/blocktree/go-owcdrivers